@@ -4,10 +4,8 @@ import (
 	"crypto"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"strings"
 	"time"
 
@@ -17,6 +15,8 @@ import (
 
 var (
 	ErrUriInvalid = errors.New("uri invalid")
+	// ErrCommandFailed the server could not complete the request.
+	ErrCommandFailed = errors.New("command failed")
 )
 
 var HttpClient = &http.Client{
@@ -25,88 +25,177 @@ var HttpClient = &http.Client{
 
 var defaultClient = &Client{}
 
+// Login answers the given SQRL URL with a new identity that
+// is generated for this login only and then discarded.
+//
+// Deprecated: the site will never recognise the user again,
+// use Client.Login with the Identity of the user instead.
 func Login(uri string) error {
-	return defaultClient.Login(uri)
+	identity, _, _, err := CreateIdentity()
+	if err != nil {
+		return err
+	}
+	c := *defaultClient
+	c.Identity = identity
+	return c.Login(uri)
 }
 
+// Client signs in to SQRL sites on behalf of the user whose
+// identity it holds. The identity key sent to each site is
+// derived from the identity for the SQRL URL being answered.
 type Client struct {
+	Identity              *Identity
 	UseInsecureConnection bool
 }
 
+// Login answers the given SQRL URL. If the site does not know the
+// current identity, each of the user's previous identities are tried
+// in turn. If the site knows a previous identity, the identity is
+// rekeyed, replacing the previous identity with the current one.
+// Otherwise the current identity is associated with the site.
 func (c *Client) Login(uri string) error {
-	var rand io.Reader
-	pub, priv, err := ed25519.GenerateKey(rand)
+	parsed, err := sqrl.ParseURL(uri)
 	if err != nil {
-		return err
+		return ErrUriInvalid
+	}
+	if c.Identity == nil {
+		return ErrNoIdentity
+	}
+
+	domain := parsed.SiteKeyDomain()
+	s := &session{
+		origin:   c.getEndpoint(parsed.Scheme + "://" + parsed.Host),
+		endpoint: c.getEndpoint(uri),
+		server:   sqrl.Base64.EncodeToString([]byte(uri)),
 	}
+	current := c.Identity.SiteKey(domain)
+	idk := publicIdentity(current)
 
-	endpoint, err := c.getEndpoint(uri)
+	serverMsg, err := s.post(QueryCmd(idk), signingKeys{ids: current})
 	if err != nil {
 		return err
 	}
+	if serverMsg.Is(sqrl.TIFCurrentIDMatch) {
+		_, err = s.post(IdentCmd(idk, "", ""), signingKeys{ids: current})
+		return err
+	}
 
-	idk := sqrl.Identity(sqrl.Base64.EncodeToString(pub))
-
-	clientParameters := QueryCmd(idk)
-	serverParameters := sqrl.Base64.EncodeToString([]byte(uri))
-
-	signMe := clientParameters + serverParameters
+	for _, iuk := range c.Identity.previous {
+		previousIdentity, err := IdentityFromIUK(iuk)
+		if err != nil {
+			return err
+		}
+		previous := previousIdentity.SiteKey(domain)
+		pidk := publicIdentity(previous)
+
+		serverMsg, err = s.post(QueryPreviousCmd(idk, pidk), signingKeys{ids: current, pids: previous})
+		if err != nil {
+			return err
+		}
+		if !serverMsg.Is(sqrl.TIFPreviousIDMatch) {
+			continue
+		}
+
+		unlock, err := UnlockRequestKey(iuk, serverMsg.Suk)
+		if err != nil {
+			return err
+		}
+		suk, vuk, err := c.Identity.LockKeys()
+		if err != nil {
+			return err
+		}
+		_, err = s.post(RekeyCmd(idk, pidk, suk, vuk), signingKeys{ids: current, pids: previous, urs: unlock})
+		return err
+	}
 
-	ids, err := sign(signMe, priv)
+	// The site does not know the user, so they are associated
+	// with the site along with the keys needed to unlock it.
+	suk, vuk, err := c.Identity.LockKeys()
 	if err != nil {
 		return err
 	}
+	_, err = s.post(IdentCmd(idk, suk, vuk), signingKeys{ids: current})
+	return err
+}
+
+// session is a series of requests answering a single SQRL URL.
+// Every request after the first is posted to the qry of the last
+// response, and includes that response as the server parameter.
+type session struct {
+	origin   string
+	endpoint string
+	server   string
+}
+
+// signingKeys are the private keys used to sign a request, the
+// previous identity and unlock request keys are optional.
+type signingKeys struct {
+	ids  ed25519.PrivateKey
+	pids ed25519.PrivateKey
+	urs  ed25519.PrivateKey
+}
+
+func (s *session) post(clientParameters string, keys signingKeys) (*sqrl.ServerMsg, error) {
+	signMe := clientParameters + s.server
 
 	form := []string{
 		"client=" + clientParameters,
-		"server=" + serverParameters,
-		"ids=" + ids,
+		"server=" + s.server,
 	}
-	serverMsg, err := do(endpoint, strings.Join(form, "&"))
-	if err != nil {
-		return err
+	for _, sig := range []struct {
+		name string
+		key  ed25519.PrivateKey
+	}{{"ids", keys.ids}, {"pids", keys.pids}, {"urs", keys.urs}} {
+		if sig.key == nil {
+			continue
+		}
+		signature, err := sign(signMe, sig.key)
+		if err != nil {
+			return nil, err
+		}
+		form = append(form, sig.name+"="+signature)
 	}
 
-	if serverMsg.Tif&sqrl.TIFCurrentIDMatch != 0 {
-		fmt.Println("The current user is known! Lets log them in...")
-	} else {
-		fmt.Println("The current user is not yet known to the server.")
+	raw, serverMsg, err := do(s.endpoint, strings.Join(form, "&"))
+	if err != nil {
+		return nil, err
+	}
+	if serverMsg.Is(sqrl.TIFCommandFailed) {
+		return nil, ErrCommandFailed
 	}
 
-	return nil
+	s.server = raw
+	s.endpoint = s.origin + serverMsg.Qry
+	return serverMsg, nil
 }
 
-func do(uri string, form string) (*sqrl.ServerMsg, error) {
+func do(uri string, form string) (string, *sqrl.ServerMsg, error) {
 	res, err := HttpClient.Post(uri, "application/x-www-form-urlencoded", strings.NewReader(form))
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
 	gotBody, err := ioutil.ReadAll(res.Body)
 	res.Body.Close()
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
-	return sqrl.ParseServer(string(gotBody))
+	raw := string(gotBody)
+	serverMsg, err := sqrl.ParseServer(raw)
+	return raw, serverMsg, err
 }
 
 // getEndpoint transforms a sqrl:// URL to a https:// URL
-func (c *Client) getEndpoint(uri string) (endpoint string, err error) {
-	parsed, err := url.Parse(uri)
-	if err != nil {
-		return "", ErrUriInvalid
+// or a qrl:// URL to a http:// URL. Only the scheme is
+// changed, the rest of the URL is posted to as given.
+func (c *Client) getEndpoint(uri string) string {
+	i := strings.Index(uri, ":")
+	scheme := "https"
+	if c.UseInsecureConnection || strings.EqualFold(uri[:i], sqrl.QRLScheme) {
+		scheme = "http"
 	}
-	if parsed.Scheme != sqrl.Scheme {
-		return "", ErrUriInvalid
-	}
-
-	parsed.Scheme = "https"
-	if c.UseInsecureConnection {
-		parsed.Scheme = "http"
-	}
-
-	return parsed.String(), nil
+	return scheme + uri[i:]
 }
 
 // sign accepts a payload to sign with the given private key
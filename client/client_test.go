@@ -6,19 +6,27 @@ import (
 	"net/url"
 	"testing"
 
+	sqrl "github.com/RaniSputnik/sqrl-go"
 	"github.com/RaniSputnik/sqrl-go/client"
 )
 
 const serverResponseKnownUser = "dmVyPTENCm51dD01aHFaS3VIeXE1dDZ5Mmlmb1czd1B3DQp0aWY9NQ0KcXJ5PS9zcXJsP251dD01aHFaS3VIeXE1dDZ5Mmlmb1czd1B3DQo"
 
 func TestLogin(t *testing.T) {
+	c := &client.Client{Identity: anyIdentity()}
+
 	t.Run("RejectsEmptyUrl", func(t *testing.T) {
 		invalidUri := ":"
-		expectErr(t, client.ErrUriInvalid, client.Login(invalidUri))
+		expectErr(t, client.ErrUriInvalid, c.Login(invalidUri))
 	})
 
 	t.Run("RejectsUriWithoutSQRLProtocol", func(t *testing.T) {
-		expectErr(t, client.ErrUriInvalid, client.Login("https://example.com"))
+		expectErr(t, client.ErrUriInvalid, c.Login("https://example.com"))
+	})
+
+	t.Run("RejectsClientWithoutIdentity", func(t *testing.T) {
+		noIdentity := &client.Client{}
+		expectErr(t, client.ErrNoIdentity, noIdentity.Login("sqrl://example.com/cli.sqrl?nut=abc123"))
 	})
 
 	t.Run("PostsQueryRequestToServer", func(t *testing.T) {
@@ -29,19 +37,133 @@ func TestLogin(t *testing.T) {
 			w.Write([]byte(serverResponseKnownUser))
 		}))
 		defer s.Close()
-		client.HttpClient = s.Client()
+		defer useHTTPClient(s.Client())()
 
 		serverURL, _ := url.Parse(s.URL)
 		serverURL.Scheme = "sqrl"
 		sqrlUri := serverURL.String()
 
 		t.Logf("Making request to SQRL server: '%s'", sqrlUri)
-		expectErr(t, nil, client.Login(sqrlUri))
+		expectErr(t, nil, c.Login(sqrlUri))
 
 		if receivedRequest == nil {
 			t.Errorf("Expected request to test server, but it was never made")
 		}
 	})
+
+	t.Run("PostsToPlainHTTPForQRLScheme", func(t *testing.T) {
+		var receivedRequest *http.Request
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if receivedRequest == nil {
+				receivedRequest = r
+			}
+			//nolint:errcheck
+			w.Write([]byte(serverResponseKnownUser))
+		}))
+		defer s.Close()
+		defer useHTTPClient(s.Client())()
+
+		serverURL, _ := url.Parse(s.URL)
+		serverURL.Scheme = "qrl"
+		serverURL.Path = "/cli.sqrl"
+		serverURL.RawQuery = "nut=abc123"
+		qrlUri := serverURL.String()
+
+		expectErr(t, nil, c.Login(qrlUri))
+
+		if receivedRequest == nil {
+			t.Fatalf("Expected request to test server, but it was never made")
+		}
+		if got := receivedRequest.URL.RequestURI(); got != "/cli.sqrl?nut=abc123" {
+			t.Errorf("Expected request to '/cli.sqrl?nut=abc123', got: '%s'", got)
+		}
+	})
+
+	t.Run("PostsToTheURLAsGiven", func(t *testing.T) {
+		var receivedRequest *http.Request
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if receivedRequest == nil {
+				receivedRequest = r
+			}
+			//nolint:errcheck
+			w.Write([]byte(serverResponseKnownUser))
+		}))
+		defer s.Close()
+		defer useHTTPClient(s.Client())()
+
+		serverURL, _ := url.Parse(s.URL)
+		const requestURI = "/cli.sqrl?nut=abc123&session=a%2Fb&sfn=RXhhbXBsZQ"
+		qrlUri := "qrl://" + serverURL.Host + requestURI
+
+		expectErr(t, nil, c.Login(qrlUri))
+
+		if receivedRequest == nil {
+			t.Fatalf("Expected request to test server, but it was never made")
+		}
+		if got := receivedRequest.URL.RequestURI(); got != requestURI {
+			t.Errorf("Expected request to '%s', got: '%s'", requestURI, got)
+		}
+	})
+}
+
+func TestDeprecatedLoginGeneratesIdentity(t *testing.T) {
+	var receivedRequest *http.Request
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedRequest = r
+		//nolint:errcheck
+		w.Write([]byte(serverResponseKnownUser))
+	}))
+	defer s.Close()
+	defer useHTTPClient(s.Client())()
+
+	serverURL, _ := url.Parse(s.URL)
+	serverURL.Scheme = "sqrl"
+
+	expectErr(t, nil, client.Login(serverURL.String()))
+	if receivedRequest == nil {
+		t.Errorf("Expected request to test server, but it was never made")
+	}
+}
+
+func TestLoginSendsSiteSpecificIdentityKey(t *testing.T) {
+	identity := anyIdentity()
+	c := &client.Client{Identity: identity}
+
+	var gotClient *sqrl.ClientMsg
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//nolint:errcheck
+		r.ParseForm()
+		gotClient, _ = sqrl.ParseClient(r.Form.Get("client"))
+		//nolint:errcheck
+		w.Write([]byte(serverResponseKnownUser))
+	}))
+	defer s.Close()
+	defer useHTTPClient(s.Client())()
+
+	serverURL, _ := url.Parse(s.URL)
+	qrlUri := "qrl://" + serverURL.Host + "/cli.sqrl?nut=abc123"
+	expectErr(t, nil, c.Login(qrlUri))
+
+	parsed, _ := sqrl.ParseURL(qrlUri)
+	if gotClient == nil {
+		t.Fatalf("Expected client param to be sent")
+	}
+	if want := identity.Idk(parsed); gotClient.Idk != want {
+		t.Errorf("Expected idk '%s', got: '%s'", want, gotClient.Idk)
+	}
+}
+
+// useHTTPClient makes requests with the given HTTP client,
+// call the returned func to restore the previous client.
+func useHTTPClient(c *http.Client) func() {
+	previous := client.HttpClient
+	client.HttpClient = c
+	return func() { client.HttpClient = previous }
+}
+
+func anyIdentity() *client.Identity {
+	identity, _, _, _ := client.CreateIdentity()
+	return identity
 }
 
 func expectErr(t *testing.T, expect, got error) {
@@ -6,12 +6,9 @@ import (
 
 func QueryCmd(idk sqrl.Identity) string {
 	query := sqrl.ClientMsg{
-		Ver: v1Only,
+		Ver: sqrl.SupportedVersions.Strings(),
 		Cmd: sqrl.CmdQuery,
 		Idk: idk,
-		// TODO: "pidk=" + previousIdentityKey,
-		// TODO: "suk=" + serverUnlockKey,
-		// TODO: "vuk=" + verifyUnlockKey,
 	}
 
 	// Swallow error, only occurs if we have
@@ -20,4 +17,57 @@ func QueryCmd(idk sqrl.Identity) string {
 	return val
 }
 
-var v1Only = []string{sqrl.V1}
+// IdentCmd returns the client parameter of an ident request. The server
+// and verify unlock keys should be provided if the site does not yet know
+// the identity, see Identity.LockKeys.
+func IdentCmd(idk, suk, vuk sqrl.Identity) string {
+	ident := sqrl.ClientMsg{
+		Ver: sqrl.SupportedVersions.Strings(),
+		Cmd: sqrl.CmdIdent,
+		Idk: idk,
+		Suk: suk,
+		Vuk: vuk,
+	}
+
+	// Swallow error, only occurs if we have
+	// an incomplete client msg
+	val, _ := ident.Encode()
+	return val
+}
+
+// QueryPreviousCmd returns the client parameter of a query that also
+// asserts a previous identity. The server unlock key of the previous
+// identity is requested, as it is needed to rekey the identity.
+func QueryPreviousCmd(idk, pidk sqrl.Identity) string {
+	query := sqrl.ClientMsg{
+		Ver:  sqrl.SupportedVersions.Strings(),
+		Cmd:  sqrl.CmdQuery,
+		Idk:  idk,
+		Pidk: pidk,
+		Opt:  []sqrl.Opt{sqrl.OptSUK},
+	}
+
+	// Swallow error, only occurs if we have
+	// an incomplete client msg
+	val, _ := query.Encode()
+	return val
+}
+
+// RekeyCmd returns the client parameter of an ident request that
+// replaces the previous identity known to the site with the current
+// identity, along with new server and verify unlock keys.
+func RekeyCmd(idk, pidk, suk, vuk sqrl.Identity) string {
+	ident := sqrl.ClientMsg{
+		Ver:  sqrl.SupportedVersions.Strings(),
+		Cmd:  sqrl.CmdIdent,
+		Idk:  idk,
+		Pidk: pidk,
+		Suk:  suk,
+		Vuk:  vuk,
+	}
+
+	// Swallow error, only occurs if we have
+	// an incomplete client msg
+	val, _ := ident.Encode()
+	return val
+}
@@ -31,7 +31,7 @@ func TestExampleServer(t *testing.T) {
 		t.Fatalf("Challenge is in unexpected format")
 	}
 
-	c := client.Client{UseInsecureConnection: true}
+	c := client.Client{Identity: anyIdentity(), UseInsecureConnection: true}
 	expectErr(t, nil, c.Login(challenge))
 }
 
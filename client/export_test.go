@@ -0,0 +1,9 @@
+package client
+
+import sqrl "github.com/RaniSputnik/sqrl-go"
+
+// LockKeysFromRLK returns the lock keys of the identity
+// derived from the given, rather than a random, lock key.
+func LockKeysFromRLK(id *Identity, rlk []byte) (suk, vuk sqrl.Identity) {
+	return id.lockKeys(rlk)
+}
@@ -0,0 +1,156 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	sqrl "github.com/RaniSputnik/sqrl-go"
+	"github.com/RaniSputnik/sqrl-go/s4"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	// IMKLength is the length in bytes of an Identity Master Key.
+	IMKLength = 32
+	// ILKLength is the length in bytes of an Identity Lock Key.
+	ILKLength = 32
+)
+
+var (
+	// ErrInvalidIMK the identity master key is not IMKLength bytes.
+	ErrInvalidIMK = errors.New("invalid identity master key")
+	// ErrInvalidILK the identity lock key is not ILKLength bytes.
+	ErrInvalidILK = errors.New("invalid identity lock key")
+	// ErrNoIdentity the client has no identity to sign in with.
+	ErrNoIdentity = errors.New("no identity")
+)
+
+// Identity is a SQRL identity, represented by its Identity Master Key (IMK).
+// A different key pair is derived from the IMK for every site the user signs
+// in to, so that sites are unable to correlate their users.
+//
+// The Identity Lock Key (ILK) is used to create the unlock keys given to
+// each site, so that the user can later prove they hold the identity
+// unlock key, see LockKeys.
+type Identity struct {
+	imk []byte
+	ilk []byte
+
+	// previous are the identity unlock keys of the
+	// user's previous identities, most recent first.
+	previous [][]byte
+}
+
+// NewIdentity returns the identity for the given
+// Identity Master Key and Identity Lock Key.
+func NewIdentity(imk, ilk []byte) (*Identity, error) {
+	if len(imk) != IMKLength {
+		return nil, ErrInvalidIMK
+	}
+	if len(ilk) != ILKLength {
+		return nil, ErrInvalidILK
+	}
+	return &Identity{
+		imk: append([]byte(nil), imk...),
+		ilk: append([]byte(nil), ilk...),
+	}, nil
+}
+
+// LoadIdentity reads an identity stored in the S4 format, as exported
+// by the reference client, unlocking it with the user's password.
+func LoadIdentity(data []byte, password []byte) (*Identity, error) {
+	stored, err := s4.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	imk, ilk, err := stored.Access.Open(password)
+	if err != nil {
+		return nil, err
+	}
+	id, err := NewIdentity(imk, ilk)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.Previous != nil {
+		previous, err := stored.Previous.Open(imk)
+		if err != nil {
+			return nil, err
+		}
+		if err := id.SetPrevious(previous); err != nil {
+			return nil, err
+		}
+	}
+	return id, nil
+}
+
+// SetPrevious sets the identity unlock keys of up to four of the
+// user's previous identities, most recent first. When a site does
+// not know the current identity, the previous identities are tried
+// so that the site can replace them with the current identity.
+func (id *Identity) SetPrevious(iuks [][]byte) error {
+	if len(iuks) > s4.MaxPrevious {
+		return fmt.Errorf("at most %d previous identities are allowed", s4.MaxPrevious)
+	}
+	previous := make([][]byte, len(iuks))
+	for i, iuk := range iuks {
+		if len(iuk) != IUKLength {
+			return ErrInvalidIUK
+		}
+		previous[i] = append([]byte(nil), iuk...)
+	}
+	id.previous = previous
+	return nil
+}
+
+// AccessBlock returns the password protected S4 block
+// that the identity should be stored in.
+func (id *Identity) AccessBlock(password []byte, iterations uint32) (*s4.AccessBlock, error) {
+	return s4.NewAccessBlock(password, id.imk, id.ilk, iterations)
+}
+
+// SiteKey derives the private key used to sign requests to the site with
+// the given site key domain, see sqrl.URL.SiteKeyDomain. The private key
+// is seeded with the HMAC-SHA256 of the domain, keyed by the IMK.
+func (id *Identity) SiteKey(domain string) ed25519.PrivateKey {
+	mac := hmac.New(sha256.New, id.imk)
+	mac.Write([]byte(domain))
+	return ed25519.NewKeyFromSeed(mac.Sum(nil))
+}
+
+// Idk returns the identity key that is sent to the site
+// the given SQRL URL was issued by.
+func (id *Identity) Idk(u *sqrl.URL) sqrl.Identity {
+	return publicIdentity(id.SiteKey(u.SiteKeyDomain()))
+}
+
+// LockKeys generates a new server unlock key (suk) and verify unlock
+// key (vuk) to send to a site when the identity is first associated
+// with it. A new random lock key is used for every site, so the keys
+// can not be used to correlate users.
+//
+// Only the holder of the identity unlock key can later sign an unlock
+// request that verifies with the vuk, see UnlockRequestKey.
+func (id *Identity) LockKeys() (suk, vuk sqrl.Identity, err error) {
+	rlk := make([]byte, 32)
+	if _, err := rand.Read(rlk); err != nil {
+		return "", "", err
+	}
+	suk, vuk = id.lockKeys(rlk)
+	return suk, vuk, nil
+}
+
+// lockKeys returns the server unlock key and verify
+// unlock key derived from the given random lock key.
+func (id *Identity) lockKeys(rlk []byte) (suk, vuk sqrl.Identity) {
+	vukKey := ed25519.NewKeyFromSeed(dhka(id.ilk, rlk))
+	suk = sqrl.Identity(sqrl.Base64.EncodeToString(makePublic(rlk)))
+	return suk, publicIdentity(vukKey)
+}
+
+func publicIdentity(key ed25519.PrivateKey) sqrl.Identity {
+	return sqrl.Identity(sqrl.Base64.EncodeToString(key.Public().(ed25519.PublicKey)))
+}
@@ -0,0 +1,150 @@
+package client_test
+
+import (
+	"bytes"
+	"testing"
+
+	sqrl "github.com/RaniSputnik/sqrl-go"
+	"github.com/RaniSputnik/sqrl-go/client"
+	"github.com/RaniSputnik/sqrl-go/s4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ed25519"
+)
+
+var anyILK = bytes.Repeat([]byte{0x07}, client.ILKLength)
+
+func TestNewIdentity(t *testing.T) {
+	t.Run("RejectsShortIMK", func(t *testing.T) {
+		_, err := client.NewIdentity(make([]byte, client.IMKLength-1), anyILK)
+		assert.Equal(t, client.ErrInvalidIMK, err)
+	})
+
+	t.Run("RejectsShortILK", func(t *testing.T) {
+		_, err := client.NewIdentity(make([]byte, client.IMKLength), make([]byte, client.ILKLength-1))
+		assert.Equal(t, client.ErrInvalidILK, err)
+	})
+
+	t.Run("CopiesIMK", func(t *testing.T) {
+		imk := make([]byte, client.IMKLength)
+		identity, _ := client.NewIdentity(imk, anyILK)
+		before := identity.SiteKey("example.com")
+		imk[0] = 1
+		assert.Equal(t, before, identity.SiteKey("example.com"))
+	})
+}
+
+func TestLoadIdentity(t *testing.T) {
+	imk := bytes.Repeat([]byte{0x2a}, client.IMKLength)
+	ilk := bytes.Repeat([]byte{0x2b}, client.IMKLength)
+	access, err := s4.NewAccessBlock([]byte("password"), imk, ilk, 1)
+	if err != nil {
+		t.Fatalf("Failed to create access block: %v", err)
+	}
+	data, _ := (&s4.Identity{Access: access}).EncodeText()
+	want, _ := client.NewIdentity(imk, ilk)
+
+	t.Run("UnlocksWithPassword", func(t *testing.T) {
+		got, err := client.LoadIdentity([]byte(data), []byte("password"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, want.SiteKey("example.com"), got.SiteKey("example.com"))
+		}
+	})
+
+	t.Run("FailsWithWrongPassword", func(t *testing.T) {
+		_, err := client.LoadIdentity([]byte(data), []byte("wrong"))
+		assert.Equal(t, s4.ErrDecrypt, err)
+	})
+}
+
+func TestIdentitySiteKey(t *testing.T) {
+	imk := bytes.Repeat([]byte{0x2a}, client.IMKLength)
+	identity, _ := client.NewIdentity(imk, anyILK)
+
+	t.Run("IsStableForTheSameSite", func(t *testing.T) {
+		assert.Equal(t, identity.SiteKey("example.com"), identity.SiteKey("example.com"))
+
+		other, _ := client.NewIdentity(imk, anyILK)
+		assert.Equal(t, identity.SiteKey("example.com"), other.SiteKey("example.com"))
+	})
+
+	t.Run("MatchesKnownAnswer", func(t *testing.T) {
+		// The public key of the Ed25519 seed HMAC-SHA256(imk, "example.com")
+		public := identity.SiteKey("example.com").Public().(ed25519.PublicKey)
+		assert.Equal(t, "PazZFSCmGbwpJNS0exlx7-LdCh-lLT4DwYHuB0dUWIs", sqrl.Base64.EncodeToString(public))
+	})
+
+	t.Run("DiffersBetweenSites", func(t *testing.T) {
+		assert.NotEqual(t, identity.SiteKey("example.com"), identity.SiteKey("example.org"))
+	})
+
+	t.Run("DiffersBetweenIdentities", func(t *testing.T) {
+		other, _ := client.NewIdentity(bytes.Repeat([]byte{0x2b}, client.IMKLength), anyILK)
+		assert.NotEqual(t, identity.SiteKey("example.com"), other.SiteKey("example.com"))
+	})
+}
+
+func TestIdentityIdk(t *testing.T) {
+	identity, _ := client.NewIdentity(bytes.Repeat([]byte{0x2a}, client.IMKLength), anyILK)
+	idk := func(raw string) sqrl.Identity {
+		u, err := sqrl.ParseURL(raw)
+		if err != nil {
+			t.Fatalf("Failed to parse '%s': %v", raw, err)
+		}
+		return identity.Idk(u)
+	}
+
+	t.Run("IgnoresPortCaseAndPath", func(t *testing.T) {
+		want := idk("sqrl://example.com/cli.sqrl?nut=abc")
+		assert.Equal(t, want, idk("sqrl://EXAMPLE.com:8080/cli.sqrl?nut=def"))
+		assert.Equal(t, want, idk("qrl://example.com/other?nut=abc"))
+	})
+
+	t.Run("IncludesPathExtension", func(t *testing.T) {
+		assert.NotEqual(t, idk("sqrl://example.com/app/cli.sqrl"), idk("sqrl://example.com/app/cli.sqrl?x=4"))
+		assert.Equal(t, idk("sqrl://example.com/app/cli.sqrl?x=4"), idk("sqrl://example.com/app/other?x=4"))
+	})
+
+	t.Run("CanVerifySignatures", func(t *testing.T) {
+		u, _ := sqrl.ParseURL("sqrl://example.com/cli.sqrl")
+		key := identity.SiteKey(u.SiteKeyDomain())
+		sig := sqrl.Signature(sqrl.Base64.EncodeToString(ed25519.Sign(key, []byte("payload"))))
+		assert.True(t, sig.Verify(identity.Idk(u), "payload"))
+	})
+}
+
+func TestIdentitySetPrevious(t *testing.T) {
+	identity, _ := client.NewIdentity(bytes.Repeat([]byte{0x2a}, client.IMKLength), anyILK)
+	iuk := bytes.Repeat([]byte{0x03}, client.IUKLength)
+
+	t.Run("RejectsMoreThanFour", func(t *testing.T) {
+		err := identity.SetPrevious([][]byte{iuk, iuk, iuk, iuk, iuk})
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsInvalidIUK", func(t *testing.T) {
+		err := identity.SetPrevious([][]byte{iuk[1:]})
+		assert.Equal(t, client.ErrInvalidIUK, err)
+	})
+}
+
+func TestLoadIdentityWithPrevious(t *testing.T) {
+	imk := bytes.Repeat([]byte{0x2a}, client.IMKLength)
+	access, _ := s4.NewAccessBlock([]byte("password"), imk, anyILK, 1)
+
+	t.Run("OpensPreviousBlock", func(t *testing.T) {
+		previous, _ := s4.NewPreviousBlock(imk, [][]byte{bytes.Repeat([]byte{0x03}, client.IUKLength)})
+		data, _ := (&s4.Identity{Access: access, Previous: previous}).Encode()
+
+		_, err := client.LoadIdentity(data, []byte("password"))
+		assert.NoError(t, err)
+	})
+
+	t.Run("FailsWhenPreviousBlockIsNotForIdentity", func(t *testing.T) {
+		otherIMK := bytes.Repeat([]byte{0x2b}, client.IMKLength)
+		previous, _ := s4.NewPreviousBlock(otherIMK, [][]byte{bytes.Repeat([]byte{0x03}, client.IUKLength)})
+		data, _ := (&s4.Identity{Access: access, Previous: previous}).Encode()
+
+		_, err := client.LoadIdentity(data, []byte("password"))
+		assert.Equal(t, s4.ErrDecrypt, err)
+	})
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	sqrl "github.com/RaniSputnik/sqrl-go"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	// IUKLength is the length in bytes of an Identity Unlock Key.
+	IUKLength = 32
+	// RescueCodeLength is the number of decimal
+	// digits in the rescue code of an identity.
+	RescueCodeLength = 24
+
+	enHashIterations = 16
+)
+
+var (
+	// ErrInvalidIUK the identity unlock key is not IUKLength bytes.
+	ErrInvalidIUK = errors.New("invalid identity unlock key")
+	// ErrInvalidSUK the server unlock key is not a valid
+	// base64 encoded Curve25519 public key.
+	ErrInvalidSUK = errors.New("invalid server unlock key")
+)
+
+// CreateIdentity generates a brand new identity.
+//
+// The Identity Unlock Key (IUK) is returned alongside the identity, it is
+// needed to unlock the identity with a site and to rekey the identity. It
+// must never be stored with the identity, instead it should be protected
+// by the rescue code (see s4.NewRescueBlock) which the user writes down.
+func CreateIdentity() (id *Identity, iuk []byte, rescueCode string, err error) {
+	iuk = make([]byte, IUKLength)
+	if _, err := rand.Read(iuk); err != nil {
+		return nil, nil, "", err
+	}
+	if rescueCode, err = GenerateRescueCode(); err != nil {
+		return nil, nil, "", err
+	}
+	if id, err = IdentityFromIUK(iuk); err != nil {
+		return nil, nil, "", err
+	}
+	return id, iuk, rescueCode, nil
+}
+
+// IdentityFromIUK returns the identity of the given Identity Unlock Key.
+// The Identity Master Key is the EnHash of the IUK and the Identity Lock
+// Key is the Curve25519 public key of the IUK.
+func IdentityFromIUK(iuk []byte) (*Identity, error) {
+	if len(iuk) != IUKLength {
+		return nil, ErrInvalidIUK
+	}
+	return NewIdentity(EnHash(iuk), makePublic(iuk))
+}
+
+// GenerateRescueCode returns a new random rescue code
+// of RescueCodeLength decimal digits.
+func GenerateRescueCode() (string, error) {
+	code := make([]byte, RescueCodeLength)
+	ten := big.NewInt(10)
+	for i := range code {
+		digit, err := rand.Int(rand.Reader, ten)
+		if err != nil {
+			return "", err
+		}
+		code[i] = '0' + byte(digit.Int64())
+	}
+	return string(code), nil
+}
+
+// EnHash is the SHA-256 of the input, iterated 16 times with
+// the output of each iteration XORed together.
+func EnHash(input []byte) []byte {
+	res := make([]byte, sha256.Size)
+	sum := sha256.Sum256(input)
+	for i := 0; i < enHashIterations; i++ {
+		for j := range res {
+			res[j] ^= sum[j]
+		}
+		sum = sha256.Sum256(sum[:])
+	}
+	return res
+}
+
+// UnlockRequestKey derives the private key used to sign the unlock request
+// signature (urs) sent to the site that holds the given server unlock key.
+// The public key of the returned private key is the verify unlock key (vuk)
+// that was given to the site.
+func UnlockRequestKey(iuk []byte, suk sqrl.Identity) (ed25519.PrivateKey, error) {
+	if len(iuk) != IUKLength {
+		return nil, ErrInvalidIUK
+	}
+	sukBytes, err := sqrl.Base64.DecodeString(string(suk))
+	if err != nil || len(sukBytes) != 32 {
+		return nil, ErrInvalidSUK
+	}
+	return ed25519.NewKeyFromSeed(dhka(sukBytes, iuk)), nil
+}
+
+// SignUnlock returns the unlock request signature (urs) of the
+// given payload, for the site that holds the server unlock key.
+//
+// The payload should be the value of the 'server' parameter
+// appended to the value of the 'client' parameter.
+func SignUnlock(iuk []byte, suk sqrl.Identity, payload string) (sqrl.Signature, error) {
+	key, err := UnlockRequestKey(iuk, suk)
+	if err != nil {
+		return "", err
+	}
+	return sqrl.Signature(sqrl.Base64.EncodeToString(ed25519.Sign(key, []byte(payload)))), nil
+}
+
+// makePublic returns the Curve25519 public key of the private key.
+func makePublic(private []byte) []byte {
+	var dst, in [32]byte
+	copy(in[:], private)
+	curve25519.ScalarBaseMult(&dst, &in)
+	return dst[:]
+}
+
+// dhka is the Diffie-Hellman key agreement of the
+// public key of one party with the private key of another.
+func dhka(public, private []byte) []byte {
+	var dst, in, base [32]byte
+	copy(in[:], private)
+	copy(base[:], public)
+	curve25519.ScalarMult(&dst, &in, &base)
+	return dst[:]
+}
@@ -0,0 +1,140 @@
+package client_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	sqrl "github.com/RaniSputnik/sqrl-go"
+	"github.com/RaniSputnik/sqrl-go/client"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ed25519"
+)
+
+// The known answers for these keys were computed
+// independently of this package, with OpenSSL.
+var (
+	knownIUK = bytes.Repeat([]byte{0x2a}, client.IUKLength)
+	knownRLK = bytes.Repeat([]byte{0x2b}, 32)
+)
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestCreateIdentity(t *testing.T) {
+	id, iuk, rescueCode, err := client.CreateIdentity()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, iuk, client.IUKLength)
+	assert.Len(t, rescueCode, client.RescueCodeLength)
+
+	fromIUK, err := client.IdentityFromIUK(iuk)
+	if assert.NoError(t, err) {
+		assert.Equal(t, fromIUK, id)
+	}
+
+	_, other, _, _ := client.CreateIdentity()
+	assert.NotEqual(t, iuk, other, "Expected a new IUK for every identity")
+}
+
+func TestIdentityFromIUK(t *testing.T) {
+	t.Run("RejectsShortIUK", func(t *testing.T) {
+		_, err := client.IdentityFromIUK(make([]byte, client.IUKLength-1))
+		assert.Equal(t, client.ErrInvalidIUK, err)
+	})
+
+	t.Run("DerivesIMKAndILK", func(t *testing.T) {
+		imk := mustDecodeHex("924006c60a8248f9d7e78f64f7d526f22d292b62cdd8da4ae9aabb423dea66ea")
+		ilk := mustDecodeHex("07aaff3e9fc167275544f4c3a6a17cd837f2ec6e78cd8a57b1e3dfb3cc035a76")
+		want, _ := client.NewIdentity(imk, ilk)
+
+		got, err := client.IdentityFromIUK(knownIUK)
+		if assert.NoError(t, err) {
+			assert.Equal(t, want, got)
+		}
+	})
+}
+
+func TestGenerateRescueCode(t *testing.T) {
+	code, err := client.GenerateRescueCode()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, code, client.RescueCodeLength)
+	for _, c := range code {
+		assert.True(t, c >= '0' && c <= '9', "Expected only digits, got: '%s'", code)
+	}
+
+	other, _ := client.GenerateRescueCode()
+	assert.NotEqual(t, code, other)
+}
+
+func TestEnHash(t *testing.T) {
+	want := mustDecodeHex("34898e45c80e1acd15eb226d03e6bf40e8293f8f10db4bd3b177b4c7b600ffe7")
+	assert.Equal(t, want, client.EnHash([]byte("sqrl")))
+	assert.NotEqual(t, want, client.EnHash([]byte("sqrL")))
+}
+
+func TestLockKeys(t *testing.T) {
+	const (
+		wantSuk = sqrl.Identity("RcfgFQstarnyXIJXGUsUmMdCIhgTabXh_w2XqYUVmlM")
+		wantVuk = sqrl.Identity("Rk88R08JR4nGm787GShHSg6YXDf2V3QulXtwtScEINA")
+	)
+	id, _ := client.IdentityFromIUK(knownIUK)
+
+	t.Run("DerivesSukAndVukFromRLK", func(t *testing.T) {
+		suk, vuk := client.LockKeysFromRLK(id, knownRLK)
+		assert.Equal(t, wantSuk, suk)
+		assert.Equal(t, wantVuk, vuk)
+	})
+
+	t.Run("DerivesUnlockRequestKeyForVuk", func(t *testing.T) {
+		key, err := client.UnlockRequestKey(knownIUK, wantSuk)
+		if assert.NoError(t, err) {
+			public := key.Public().(ed25519.PublicKey)
+			assert.Equal(t, string(wantVuk), sqrl.Base64.EncodeToString(public))
+		}
+	})
+}
+
+func TestUnlockRequestSignature(t *testing.T) {
+	id, iuk, _, _ := client.CreateIdentity()
+	suk, vuk, err := id.LockKeys()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("VerifiesWithVuk", func(t *testing.T) {
+		urs, err := client.SignUnlock(iuk, suk, "payload")
+		if assert.NoError(t, err) {
+			assert.True(t, urs.Verify(vuk, "payload"))
+		}
+	})
+
+	t.Run("DoesNotVerifyWithOtherIUK", func(t *testing.T) {
+		_, otherIUK, _, _ := client.CreateIdentity()
+		urs, err := client.SignUnlock(otherIUK, suk, "payload")
+		if assert.NoError(t, err) {
+			assert.False(t, urs.Verify(vuk, "payload"))
+		}
+	})
+
+	t.Run("LockKeysDifferForEverySite", func(t *testing.T) {
+		otherSuk, otherVuk, err := id.LockKeys()
+		if assert.NoError(t, err) {
+			assert.NotEqual(t, suk, otherSuk)
+			assert.NotEqual(t, vuk, otherVuk)
+		}
+	})
+
+	t.Run("RejectsInvalidSuk", func(t *testing.T) {
+		_, err := client.SignUnlock(iuk, sqrl.Identity("invalid"), "payload")
+		assert.Equal(t, client.ErrInvalidSUK, err)
+	})
+}
@@ -31,7 +31,8 @@ func TestLiveServer(t *testing.T) {
 	}
 
 	waitForGRCWaitLimit()
-	expectErr(t, nil, client.Login(challenge))
+	c := client.Client{Identity: anyIdentity()}
+	expectErr(t, nil, c.Login(challenge))
 }
 
 func waitForGRCWaitLimit() {
@@ -0,0 +1,123 @@
+package client_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	sqrl "github.com/RaniSputnik/sqrl-go"
+	"github.com/RaniSputnik/sqrl-go/client"
+	"github.com/RaniSputnik/sqrl-go/ssp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginRekeysPreviousIdentity(t *testing.T) {
+	ctx := context.Background()
+	store := ssp.NewMemoryStore()
+	server := ssp.Configure(make([]byte, 16), "http://example.com/auth/callback")
+	s := httptest.NewServer(server.ClientHandler(store, ssp.DefaultExchange(make([]byte, 16), time.Minute)))
+	defer s.Close()
+	defer useHTTPClient(s.Client())()
+
+	host := mustParse(t, s.URL).Host
+	server.WithHosts(host)
+	loginURL := (&sqrl.URL{
+		Scheme: sqrl.QRLScheme,
+		Host:   host,
+		Path:   "/cli.sqrl",
+		Nut:    server.Nut("127.0.0.1"),
+		SFN:    host,
+	}).String()
+	parsed, _ := sqrl.ParseURL(loginURL)
+
+	// The site knows the user by an identity they have since replaced
+	previousIdentity, previousIUK, _, _ := client.CreateIdentity()
+	suk, vuk, _ := previousIdentity.LockKeys()
+	user, _ := store.CreateUser(ctx, previousIdentity.Idk(parsed))
+	user.Suk, user.Vuk = suk, vuk
+	//nolint:errcheck
+	store.SaveUser(ctx, user)
+
+	current, _, _, _ := client.CreateIdentity()
+	_, otherIUK, _, _ := client.CreateIdentity()
+	if err := current.SetPrevious([][]byte{otherIUK, previousIUK}); err != nil {
+		t.Fatalf("Failed to set previous identities: %v", err)
+	}
+
+	c := &client.Client{Identity: current}
+	expectErr(t, nil, c.Login(loginURL))
+
+	got, _ := store.GetUserByIdentity(ctx, current.Idk(parsed))
+	if assert.NotNil(t, got, "Expected the current identity to be known") {
+		assert.Equal(t, user.Id, got.Id)
+		assert.NotEqual(t, suk, got.Suk, "Expected new unlock keys")
+	}
+	old, _ := store.GetUserByIdentity(ctx, previousIdentity.Idk(parsed))
+	assert.Nil(t, old, "Expected the previous identity to be replaced")
+}
+
+func TestLoginCreatesUserThenRekeys(t *testing.T) {
+	ctx := context.Background()
+	store := ssp.NewMemoryStore()
+	server := ssp.Configure(make([]byte, 16), "http://example.com/auth/callback")
+	s := httptest.NewServer(server.ClientHandler(store, ssp.DefaultExchange(make([]byte, 16), time.Minute)))
+	defer s.Close()
+	defer useHTTPClient(s.Client())()
+
+	host := mustParse(t, s.URL).Host
+	server.WithHosts(host)
+	loginURL := func() string {
+		return (&sqrl.URL{
+			Scheme: sqrl.QRLScheme,
+			Host:   host,
+			Path:   "/cli.sqrl",
+			Nut:    server.Nut("127.0.0.1"),
+			SFN:    host,
+		}).String()
+	}
+	parsed, _ := sqrl.ParseURL(loginURL())
+
+	// The first login associates the identity with the site
+	original, originalIUK, _, _ := client.CreateIdentity()
+	expectErr(t, nil, (&client.Client{Identity: original}).Login(loginURL()))
+
+	created, _ := store.GetUserByIdentity(ctx, original.Idk(parsed))
+	if !assert.NotNil(t, created, "Expected the identity to be known") {
+		return
+	}
+	userID, suk := created.Id, created.Suk
+	assert.NotEmpty(t, suk, "Expected a server unlock key")
+	assert.NotEmpty(t, created.Vuk, "Expected a verify unlock key")
+
+	// Later logins with the same identity sign in to the same user
+	expectErr(t, nil, (&client.Client{Identity: original}).Login(loginURL()))
+	again, _ := store.GetUserByIdentity(ctx, original.Idk(parsed))
+	if assert.NotNil(t, again) {
+		assert.Equal(t, userID, again.Id)
+	}
+
+	// The user replaces their identity, the site follows the rekey
+	rekeyed, _, _, _ := client.CreateIdentity()
+	if err := rekeyed.SetPrevious([][]byte{originalIUK}); err != nil {
+		t.Fatalf("Failed to set previous identities: %v", err)
+	}
+	expectErr(t, nil, (&client.Client{Identity: rekeyed}).Login(loginURL()))
+
+	got, _ := store.GetUserByIdentity(ctx, rekeyed.Idk(parsed))
+	if assert.NotNil(t, got, "Expected the rekeyed identity to be known") {
+		assert.Equal(t, userID, got.Id)
+		assert.NotEqual(t, suk, got.Suk, "Expected new unlock keys")
+	}
+	old, _ := store.GetUserByIdentity(ctx, original.Idk(parsed))
+	assert.Nil(t, old, "Expected the original identity to be replaced")
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("Failed to parse '%s': %v", raw, err)
+	}
+	return u
+}
@@ -0,0 +1,74 @@
+// Package enscrypt implements EnScrypt, the password based key
+// derivation function used by SQRL to protect identities.
+//
+// EnScrypt repeatedly applies scrypt, using the output of each
+// iteration as the salt of the next, and XORs the outputs together.
+// The number of iterations can either be fixed or chosen by running
+// for a length of time, so that the cost of guessing a password
+// can be tuned to the speed of the machine the identity is made on.
+//
+// Reference: https://www.grc.com/sqrl/scrypt.htm
+package enscrypt
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// KeyLength is the length in bytes of the derived key.
+	KeyLength = 32
+	// LogN is the base 2 logarithm of the scrypt N parameter
+	// used by the reference client, an N of 512.
+	LogN = 9
+
+	r = 256
+	p = 1
+)
+
+// ErrInvalidIterations the number of iterations is less than one.
+var ErrInvalidIterations = errors.New("iterations must be at least one")
+
+// Key derives a key from the password and salt by running the given
+// number of EnScrypt iterations. The salt may be empty.
+func Key(password, salt []byte, logN uint8, iterations int) ([]byte, error) {
+	if iterations < 1 {
+		return nil, ErrInvalidIterations
+	}
+
+	res := make([]byte, KeyLength)
+	for i := 0; i < iterations; i++ {
+		var err error
+		if salt, err = scrypt.Key(password, salt, 1<<logN, r, p, KeyLength); err != nil {
+			return nil, err
+		}
+		xor(res, salt)
+	}
+	return res, nil
+}
+
+// KeyFor derives a key from the password and salt by running EnScrypt
+// iterations until the given duration has elapsed. The number of
+// iterations that were run is returned alongside the key, it must be
+// stored so that Key can derive the same key again. At least one
+// iteration is always run.
+func KeyFor(password, salt []byte, logN uint8, d time.Duration) (key []byte, iterations int, err error) {
+	res := make([]byte, KeyLength)
+	start := time.Now()
+	for iterations == 0 || time.Since(start) < d {
+		if salt, err = scrypt.Key(password, salt, 1<<logN, r, p, KeyLength); err != nil {
+			return nil, 0, err
+		}
+		xor(res, salt)
+		iterations++
+	}
+	return res, iterations, nil
+}
+
+func xor(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
@@ -0,0 +1,59 @@
+package enscrypt_test
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/RaniSputnik/sqrl-go/enscrypt"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sourced from the EnScrypt test vectors published by GRC
+// https://www.grc.com/sqrl/scrypt.htm
+var testVectors = []struct {
+	Name       string
+	Password   string
+	Salt       []byte
+	Iterations int
+	Expected   string
+}{
+	{"EmptyPasswordOneIteration", "", nil, 1, "a8ea62a6e1bfd20e4275011595307aa302645c1801600ef5cd79bf9d884d911c"},
+	{"EmptyPassword100Iterations", "", nil, 100, "45a42a01709a0012a37b7b6874cf16623543409d19e7740ed96741d2e99aab67"},
+	{"Password123Iterations", "password", nil, 123, "129d96d1e735618517259416a605be7094c2856a53c14ef7d4e4ba8e4ea36aeb"},
+	{"PasswordWithSalt123Iterations", "password", make([]byte, 32), 123, "2f30b9d4e5c48056177ff90a6cc9da04b648a7e8451dfa60da56c148187f6a7d"},
+}
+
+func TestKey(t *testing.T) {
+	for _, test := range testVectors {
+		t.Run(test.Name, func(t *testing.T) {
+			if testing.Short() && test.Iterations > 1 {
+				t.Skip("Skipping slow test vector in short mode")
+			}
+			got, err := enscrypt.Key([]byte(test.Password), test.Salt, enscrypt.LogN, test.Iterations)
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.Expected, hex.EncodeToString(got))
+			}
+		})
+	}
+
+	t.Run("RejectsZeroIterations", func(t *testing.T) {
+		_, err := enscrypt.Key([]byte("password"), nil, enscrypt.LogN, 0)
+		assert.Equal(t, enscrypt.ErrInvalidIterations, err)
+	})
+}
+
+func TestKeyFor(t *testing.T) {
+	password, salt := []byte("password"), make([]byte, 16)
+
+	key, iterations, err := enscrypt.KeyFor(password, salt, enscrypt.LogN, 100*time.Millisecond)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, iterations >= 1, "Expected at least one iteration, got: %d", iterations)
+
+	want, err := enscrypt.Key(password, salt, enscrypt.LogN, iterations)
+	if assert.NoError(t, err) {
+		assert.Equal(t, want, key, "Expected the same key to be derived from the reported iterations")
+	}
+}
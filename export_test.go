@@ -0,0 +1,12 @@
+package sqrl
+
+import "time"
+
+// NewNutterFromCounter creates a nutter that has already
+// issued and forgotten every nut up to the given counter.
+func NewNutterFromCounter(expiry time.Duration, counter uint32) Nutter {
+	n := NewNutter(expiry).(*blowfishNutter)
+	n.counter = counter
+	n.oldest = counter + 1
+	return n
+}
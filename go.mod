@@ -1,5 +1,7 @@
 module github.com/RaniSputnik/sqrl-go
 
+go 1.13
+
 require (
 	github.com/gorilla/mux v1.7.1
 	github.com/skip2/go-qrcode v0.0.0-20190110000554-dc11ecdae0a9
@@ -16,7 +16,7 @@ type Signature string
 // Verify determines if the given signature is valid.
 func (s Signature) Verify(id Identity, payload string) bool {
 	publicKey, err := Base64.DecodeString(string(id))
-	if err != nil {
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
 		return false
 	}
 	signature, err := Base64.DecodeString(string(s))
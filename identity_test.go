@@ -1,7 +1,38 @@
 package sqrl_test
 
-import "testing"
+import (
+	"testing"
+
+	sqrl "github.com/RaniSputnik/sqrl-go"
+	"github.com/stretchr/testify/assert"
+)
 
 func TestIdentityVerify(t *testing.T) {
+	alice, aliceSig := newIDKey()
+	bob, _ := newIDKey()
+	payload := "somepayload"
+	validSig := signature(aliceSig, payload)
+
+	t.Run("ReturnsTrueForValidSignature", func(t *testing.T) {
+		assert.True(t, validSig.Verify(alice, payload))
+	})
+
+	t.Run("ReturnsFalseForDifferentPayload", func(t *testing.T) {
+		assert.False(t, validSig.Verify(alice, "someotherpayload"))
+	})
+
+	t.Run("ReturnsFalseForDifferentIdentity", func(t *testing.T) {
+		assert.False(t, validSig.Verify(bob, payload))
+	})
+
+	t.Run("ReturnsFalseForInvalidIdentity", func(t *testing.T) {
+		assert.False(t, validSig.Verify("", payload))
+		assert.False(t, validSig.Verify("abc123", payload))
+		assert.False(t, validSig.Verify("!!!", payload))
+	})
 
+	t.Run("ReturnsFalseForInvalidSignature", func(t *testing.T) {
+		assert.False(t, sqrl.Signature("").Verify(alice, payload))
+		assert.False(t, sqrl.Signature("!!!").Verify(alice, payload))
+	})
 }
@@ -2,41 +2,89 @@ package sqrl
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 )
 
+// keyLength is the length in bytes of every public
+// key (idk, pidk, suk, vuk) sent by a SQRL client.
+const keyLength = 32
+
 // ClientMsg is used to represent the values
 // sent by the client to the server.
 //
 // Ver are the versions of SQRL the client supports.
 // Cmd is the command type for this request.
+// Idk is the current identity key of the user.
+// Pidk is the previous identity key of the user, if any.
+// Suk and Vuk are the server unlock and verify unlock keys
+// that the client provides when creating an identity.
+// Ins and Pins are the responses to a secret index (sin)
+// request, for the current and previous identities.
+// Btn is the button the user selected in response to
+// a server ask (1, 2 or 3) or zero if no reply was given.
+// Extra are any parameters not listed above, they are
+// encoded after the known parameters in the given order.
 type ClientMsg struct {
-	Ver []string
-	Cmd Cmd
-	Idk Identity
+	Ver  []string
+	Cmd  Cmd
+	Idk  Identity
+	Pidk Identity
+	Suk  Identity
+	Vuk  Identity
+	Ins  string
+	Pins string
 
 	Opt []Opt
+	Btn int
+
+	Extra Params
 }
 
+var clientKeys = []string{"ver", "cmd", "idk", "pidk", "suk", "vuk", "ins", "pins", "opt", "btn"}
+
 // Encode writes the client message to a string
 // ready for transmission to a SQRL server.
 func (m *ClientMsg) Encode() (string, error) {
 	if len(m.Ver) == 0 || m.Cmd == "" || m.Idk == "" {
 		return "", errors.New("incomplete client message")
 	}
+	if m.Btn < 0 || m.Btn > 3 {
+		return "", fmt.Errorf("invalid button '%d', should be 1, 2 or 3", m.Btn)
+	}
 
 	vals := []string{
 		"ver=" + strings.Join(m.Ver, ","),
 		"cmd=" + string(m.Cmd),
 		"idk=" + string(m.Idk),
 	}
+	vals = appendIfSet(vals, "pidk", string(m.Pidk))
+	vals = appendIfSet(vals, "suk", string(m.Suk))
+	vals = appendIfSet(vals, "vuk", string(m.Vuk))
+	vals = appendIfSet(vals, "ins", m.Ins)
+	vals = appendIfSet(vals, "pins", m.Pins)
 	if len(m.Opt) > 0 {
 		vals = append(vals, "opt="+encodeOptions(m.Opt))
 	}
+	if m.Btn != 0 {
+		vals = append(vals, "btn="+strconv.Itoa(m.Btn))
+	}
+	vals, err := m.Extra.encode(vals, clientKeys)
+	if err != nil {
+		return "", err
+	}
 	vals = append(vals, "") // Must end with a final newline
 	return Base64.EncodeToString([]byte(strings.Join(vals, "\r\n"))), nil
 }
 
+func appendIfSet(vals []string, key string, val string) []string {
+	if val == "" {
+		return vals
+	}
+	return append(vals, key+"="+val)
+}
+
 func encodeOptions(opts []Opt) string {
 	res := ""
 	lastOpt := len(opts) - 1
@@ -62,15 +110,32 @@ func (m ClientMsg) HasOpt(query Opt) bool {
 	return false
 }
 
-// ParseClient decodes a client message from the given string
+// Versions returns the versions of the
+// protocol that are supported by the client.
+func (m *ClientMsg) Versions() (Versions, error) {
+	return ParseVersions(strings.Join(m.Ver, ","))
+}
+
+var knownCommands = map[Cmd]bool{
+	CmdQuery:   true,
+	CmdIdent:   true,
+	CmdDisable: true,
+	CmdEnable:  true,
+	CmdRemove:  true,
+}
+
+// ParseClient decodes a client message from the given string.
+// If the message is invalid a *ParseError is returned.
 func ParseClient(raw string) (*ClientMsg, error) {
-	vals, err := parseMsg(raw)
+	vals, params, err := parseMsg("client", raw)
 	if err != nil {
 		return nil, err
 	}
 
-	if vals["ver"] == "" || vals["cmd"] == "" || vals["idk"] == "" {
-		return nil, errors.New("missing required parameter")
+	for _, key := range []string{"ver", "cmd", "idk"} {
+		if vals[key] == "" {
+			return nil, &ParseError{Field: key, Reason: ReasonMissing}
+		}
 	}
 
 	ver, err := parseVer(vals["ver"])
@@ -78,14 +143,65 @@ func ParseClient(raw string) (*ClientMsg, error) {
 		return nil, err
 	}
 
+	cmd := Cmd(vals["cmd"])
+	if !knownCommands[cmd] {
+		return nil, &ParseError{Field: "cmd", Reason: ReasonUnknownCommand, Value: vals["cmd"]}
+	}
+
+	for _, key := range []string{"idk", "pidk", "suk", "vuk", "ins", "pins"} {
+		if err := validateKey(key, vals[key]); err != nil {
+			return nil, err
+		}
+	}
+
+	btn, err := parseBtn(vals["btn"])
+	if err != nil {
+		return nil, err
+	}
+
 	return &ClientMsg{
-		Ver: ver,
-		Cmd: Cmd(vals["cmd"]),
-		Idk: Identity(vals["idk"]),
-		Opt: parseOpts(vals["opt"]),
+		Ver:  ver,
+		Cmd:  cmd,
+		Idk:  Identity(vals["idk"]),
+		Pidk: Identity(vals["pidk"]),
+		Suk:  Identity(vals["suk"]),
+		Vuk:  Identity(vals["vuk"]),
+		Ins:  vals["ins"],
+		Pins: vals["pins"],
+		Opt:  parseOpts(vals["opt"]),
+		Btn:  btn,
+
+		Extra: params.without(clientKeys),
 	}, nil
 }
 
+// validateKey ensures that an optional base64 encoded
+// value decodes to exactly keyLength bytes.
+func validateKey(key string, val string) error {
+	if val == "" {
+		return nil
+	}
+	bytes, err := Base64.DecodeString(val)
+	if err != nil {
+		return &ParseError{Field: key, Reason: ReasonMalformedBase64, Value: val}
+	}
+	if len(bytes) != keyLength {
+		return &ParseError{Field: key, Reason: ReasonInvalidKeyLength, Value: val}
+	}
+	return nil
+}
+
+func parseBtn(input string) (int, error) {
+	if input == "" {
+		return 0, nil
+	}
+	btn, err := strconv.Atoi(input)
+	if err != nil || btn < 1 || btn > 3 {
+		return 0, &ParseError{Field: "btn", Reason: ReasonMalformed, Value: input}
+	}
+	return btn, nil
+}
+
 func parseOpts(input string) []Opt {
 	if input == "" {
 		return []Opt{}
@@ -1,6 +1,7 @@
 package sqrl_test
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -8,7 +9,19 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-const validIdk = sqrl.Identity("Vl4KVVRoG0C8v1VP0UEUNK2z_SYhNVYBXdoarhMljzQ")
+const (
+	validIdk  = sqrl.Identity("Vl4KVVRoG0C8v1VP0UEUNK2z_SYhNVYBXdoarhMljzQ")
+	validPidk = sqrl.Identity("scogqcRVLI1TPUl_jTyEfHQ5XHuMH-d9ljaOgTXJCWE")
+	validSuk  = sqrl.Identity("hvA0ZdC7t3Qn9bXiFwxpMr06aCKY-KHHappPmwlRJU0")
+	validVuk  = sqrl.Identity("jHvRYTWkjl7mDe8S96nN5LzD1eW1Olf5vdjR52QWCHo")
+	validIns  = "ptzzA2o7tCXILN0x6JwZ_tkn6avjNmMW5dU8Fp5_AME"
+	validPins = "a7ZKbFAMN-o0fwRiU4HoHhudJX1XY4Eq5XXasZxErPY"
+)
+
+const (
+	encodedIdentWithUnlockKeys    = "dmVyPTENCmNtZD1pZGVudA0KaWRrPVZsNEtWVlJvRzBDOHYxVlAwVUVVTksyel9TWWhOVllCWGRvYXJoTWxqelENCnN1az1odkEwWmRDN3QzUW45YlhpRnd4cE1yMDZhQ0tZLUtISGFwcFBtd2xSSlUwDQp2dWs9akh2UllUV2tqbDdtRGU4Uzk2bk41THpEMWVXMU9sZjV2ZGpSNTJRV0NIbw0K"
+	encodedQueryWithAllParameters = "dmVyPTENCmNtZD1xdWVyeQ0KaWRrPVZsNEtWVlJvRzBDOHYxVlAwVUVVTksyel9TWWhOVllCWGRvYXJoTWxqelENCnBpZGs9c2NvZ3FjUlZMSTFUUFVsX2pUeUVmSFE1WEh1TUgtZDlsamFPZ1RYSkNXRQ0KaW5zPXB0enpBMm83dENYSUxOMHg2SndaX3RrbjZhdmpObU1XNWRVOEZwNV9BTUUNCnBpbnM9YTdaS2JGQU1OLW8wZndSaVU0SG9IaHVkSlgxWFk0RXE1WFhhc1p4RXJQWQ0Kb3B0PWNwcw0KYnRuPTINCg"
+)
 
 func TestClientMsgEncode(t *testing.T) {
 	t.Run("FailsToEncodeInvalidClientMessages", func(t *testing.T) {
@@ -41,6 +54,15 @@ func TestClientMsgEncode(t *testing.T) {
 					Cmd: sqrl.CmdQuery,
 				},
 			},
+			{
+				Name: "InvalidBtn",
+				Input: sqrl.ClientMsg{
+					Ver: []string{sqrl.V1},
+					Cmd: sqrl.CmdQuery,
+					Idk: validIdk,
+					Btn: 4,
+				},
+			},
 		}
 
 		for _, test := range cases {
@@ -86,6 +108,31 @@ func TestClientMsgEncode(t *testing.T) {
 				},
 				Expect: "dmVyPTENCmNtZD1xdWVyeQ0KaWRrPVZsNEtWVlJvRzBDOHYxVlAwVUVVTksyel9TWWhOVllCWGRvYXJoTWxqelENCm9wdD1jcHN-c3FybG9ubHkNCg",
 			},
+			{
+				Name: "Ident with unlock keys",
+				Input: sqrl.ClientMsg{
+					Ver: []string{sqrl.V1},
+					Cmd: sqrl.CmdIdent,
+					Idk: validIdk,
+					Suk: validSuk,
+					Vuk: validVuk,
+				},
+				Expect: encodedIdentWithUnlockKeys,
+			},
+			{
+				Name: "Query with all parameters",
+				Input: sqrl.ClientMsg{
+					Ver:  []string{sqrl.V1},
+					Cmd:  sqrl.CmdQuery,
+					Idk:  validIdk,
+					Pidk: validPidk,
+					Ins:  validIns,
+					Pins: validPins,
+					Opt:  []sqrl.Opt{sqrl.OptCPS},
+					Btn:  2,
+				},
+				Expect: encodedQueryWithAllParameters,
+			},
 		}
 
 		for _, test := range cases {
@@ -106,16 +153,28 @@ func TestClientMsgParse(t *testing.T) {
 		validIdk := "PO2ib4BeITiKHTOGW37Mv03dES29DfhJPl6bq5JijoA"
 
 		cases := []struct {
-			Name  string
-			Input string
+			Name   string
+			Input  string
+			Field  string
+			Reason sqrl.ParseReason
 		}{
-			{"Empty", ""},
-			{"NotBase64", "notbase64!!@!@£$"},
-			{"OnlyWhitespace", "         "},
-			{"DuplicateFields", sqrl.Base64.EncodeToString([]byte("ver=1\nver=1\ncmd=query\nidk=" + validIdk))},
-			{"MissingIdkField", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=query"))},
-			{"MissingCmdField", sqrl.Base64.EncodeToString([]byte("ver=1\nidk=" + validIdk))},
-			{"MissingVerField", sqrl.Base64.EncodeToString([]byte("cmd=query\nidk=" + validIdk))},
+			{"Empty", "", "client", sqrl.ReasonMissing},
+			{"NotBase64", "notbase64!!@!@£$", "client", sqrl.ReasonMalformedBase64},
+			{"OnlyWhitespace", "         ", "client", sqrl.ReasonMalformedBase64},
+			{"DuplicateFields", sqrl.Base64.EncodeToString([]byte("ver=1\nver=1\ncmd=query\nidk=" + validIdk)), "ver", sqrl.ReasonDuplicate},
+			{"MissingIdkField", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=query")), "idk", sqrl.ReasonMissing},
+			{"MissingCmdField", sqrl.Base64.EncodeToString([]byte("ver=1\nidk=" + validIdk)), "cmd", sqrl.ReasonMissing},
+			{"MissingVerField", sqrl.Base64.EncodeToString([]byte("cmd=query\nidk=" + validIdk)), "ver", sqrl.ReasonMissing},
+			{"IdkTooShort", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=query\nidk=abc123")), "idk", sqrl.ReasonInvalidKeyLength},
+			{"PidkNotBase64", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=query\nidk=" + validIdk + "\npidk=!!!")), "pidk", sqrl.ReasonMalformedBase64},
+			{"SukTooShort", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=ident\nidk=" + validIdk + "\nsuk=abc123")), "suk", sqrl.ReasonInvalidKeyLength},
+			{"VukTooLong", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=ident\nidk=" + validIdk + "\nvuk=" + validIdk + "AAAA")), "vuk", sqrl.ReasonInvalidKeyLength},
+			{"InsNotBase64", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=query\nidk=" + validIdk + "\nins=!!!")), "ins", sqrl.ReasonMalformedBase64},
+			{"PinsTooShort", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=query\nidk=" + validIdk + "\npins=abc123")), "pins", sqrl.ReasonInvalidKeyLength},
+			{"BtnNotANumber", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=query\nidk=" + validIdk + "\nbtn=one")), "btn", sqrl.ReasonMalformed},
+			{"UnknownCommand", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=login\nidk=" + validIdk)), "cmd", sqrl.ReasonUnknownCommand},
+			{"NotKeyValue", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=query\nidk=" + validIdk + "\nbtn")), "client", sqrl.ReasonMalformed},
+			{"BtnOutOfRange", sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=query\nidk=" + validIdk + "\nbtn=4")), "btn", sqrl.ReasonMalformed},
 			// The Web extension does not lead with the version information
 			// TODO: Is this a bug in the extension? Or should we relax this constraint?
 			// https://github.com/RaniSputnik/sqrl-go/issues/12
@@ -125,11 +184,25 @@ func TestClientMsgParse(t *testing.T) {
 		for _, testCase := range cases {
 			t.Run(testCase.Name, func(t *testing.T) {
 				_, err := sqrl.ParseClient(testCase.Input)
-				assert.Error(t, err)
+				var perr *sqrl.ParseError
+				if assert.True(t, errors.As(err, &perr), "Expected a *ParseError, got: %v", err) {
+					assert.Equal(t, testCase.Field, perr.Field)
+					assert.Equal(t, testCase.Reason, perr.Reason)
+				}
 			})
 		}
 	})
 
+	t.Run("ParseErrorIncludesTheRawValue", func(t *testing.T) {
+		input := sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=query\nidk=abc123"))
+		_, err := sqrl.ParseClient(input)
+		var perr *sqrl.ParseError
+		if assert.True(t, errors.As(err, &perr)) {
+			assert.Equal(t, "abc123", perr.Value)
+			assert.Equal(t, "invalid key length 'idk': 'abc123'", perr.Error())
+		}
+	})
+
 	t.Run("ReturnsValidClient", func(t *testing.T) {
 		cases := []struct {
 			Name     string
@@ -176,6 +249,32 @@ func TestClientMsgParse(t *testing.T) {
 					Opt: []sqrl.Opt{sqrl.OptCPS, sqrl.OptSQRLOnly},
 				},
 			},
+			{
+				Name:  "Ident with unlock keys",
+				Input: encodedIdentWithUnlockKeys,
+				Expected: sqrl.ClientMsg{
+					Ver: []string{sqrl.V1},
+					Cmd: sqrl.CmdIdent,
+					Idk: validIdk,
+					Suk: validSuk,
+					Vuk: validVuk,
+					Opt: []sqrl.Opt{},
+				},
+			},
+			{
+				Name:  "Query with all parameters",
+				Input: encodedQueryWithAllParameters,
+				Expected: sqrl.ClientMsg{
+					Ver:  []string{sqrl.V1},
+					Cmd:  sqrl.CmdQuery,
+					Idk:  validIdk,
+					Pidk: validPidk,
+					Ins:  validIns,
+					Pins: validPins,
+					Opt:  []sqrl.Opt{sqrl.OptCPS},
+					Btn:  2,
+				},
+			},
 		}
 
 		for _, test := range cases {
@@ -188,3 +287,59 @@ func TestClientMsgParse(t *testing.T) {
 		}
 	})
 }
+
+func TestClientMsgExtraParams(t *testing.T) {
+	t.Run("RoundTripsUnknownParameters", func(t *testing.T) {
+		input := sqrl.Base64.EncodeToString([]byte("ver=1\r\ncmd=query\r\nzeta=last\r\nidk=" + string(validIdk) + "\r\nalpha=first\r\n"))
+
+		got, err := sqrl.ParseClient(input)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, sqrl.Params{
+			{Key: "zeta", Value: "last"},
+			{Key: "alpha", Value: "first"},
+		}, got.Extra)
+		assert.Equal(t, "first", got.Extra.Get("alpha"))
+		assert.Equal(t, "", got.Extra.Get("missing"))
+
+		encoded, err := got.Encode()
+		if assert.NoError(t, err) {
+			reparsed, err := sqrl.ParseClient(encoded)
+			if assert.NoError(t, err) {
+				assert.Equal(t, *got, *reparsed)
+			}
+		}
+	})
+
+	t.Run("EncodesExtraParametersAfterKnownParameters", func(t *testing.T) {
+		msg := sqrl.ClientMsg{
+			Ver:   []string{sqrl.V1},
+			Cmd:   sqrl.CmdQuery,
+			Idk:   validIdk,
+			Extra: sqrl.Params{{Key: "vendor", Value: "abc"}},
+		}
+		got, err := msg.Encode()
+		if assert.NoError(t, err) {
+			decoded, _ := sqrl.Base64.DecodeString(got)
+			assert.Equal(t, "ver=1\r\ncmd=query\r\nidk="+string(validIdk)+"\r\nvendor=abc\r\n", string(decoded))
+		}
+	})
+
+	t.Run("FailsToEncodeInvalidExtraParameters", func(t *testing.T) {
+		cases := map[string]sqrl.Params{
+			"KnownKey":         {{Key: "idk", Value: string(validIdk)}},
+			"DuplicateKey":     {{Key: "vendor", Value: "a"}, {Key: "vendor", Value: "b"}},
+			"EmptyKey":         {{Key: "", Value: "a"}},
+			"KeyWithEquals":    {{Key: "a=b", Value: "c"}},
+			"ValueWithNewline": {{Key: "vendor", Value: "a\r\nidk=evil"}},
+		}
+		for name, extra := range cases {
+			t.Run(name, func(t *testing.T) {
+				msg := sqrl.ClientMsg{Ver: []string{sqrl.V1}, Cmd: sqrl.CmdQuery, Idk: validIdk, Extra: extra}
+				_, err := msg.Encode()
+				assert.Error(t, err)
+			})
+		}
+	})
+}
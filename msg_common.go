@@ -1,26 +1,133 @@
 package sqrl
 
 import (
-	"errors"
 	"fmt"
 	"strings"
 )
 
-var errEmptyInput = errors.New("empty input")
+// ParseReason describes why a message could not be parsed.
+type ParseReason string
 
-func parseMsg(raw string) (map[string]string, error) {
+const (
+	// ReasonMissing a required value was not provided.
+	ReasonMissing = ParseReason("missing")
+	// ReasonDuplicate a value was provided more than once.
+	ReasonDuplicate = ParseReason("duplicate")
+	// ReasonMalformed a value is not in the expected format.
+	ReasonMalformed = ParseReason("malformed")
+	// ReasonMalformedBase64 a value is not valid base64url.
+	ReasonMalformedBase64 = ParseReason("malformed base64")
+	// ReasonInvalidKeyLength a key does not decode to 32 bytes.
+	ReasonInvalidKeyLength = ParseReason("invalid key length")
+	// ReasonUnknownCommand the client requested a command
+	// that is not part of the SQRL protocol.
+	ReasonUnknownCommand = ParseReason("unknown command")
+)
+
+// ParseError is returned when a client or server message
+// can not be parsed. Field is the name of the offending
+// parameter, or client/server if the message as a whole
+// is invalid, and Value is the raw value that was given.
+// Err, when set, is the broader error the problem belongs
+// to (eg. ErrInvalidClient) and is returned by Unwrap.
+type ParseError struct {
+	Field  string
+	Reason ParseReason
+	Value  string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("%s '%s'", e.Reason, e.Field)
+	if e.Value != "" {
+		msg = fmt.Sprintf("%s: '%s'", msg, e.Value)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", e.Err, msg)
+	}
+	return msg
+}
+
+// Unwrap returns the broader error the problem belongs to, if any.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Param is a single key=value parameter of a message.
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params are the parameters of a message that are not
+// otherwise understood by this package, in the order
+// they appeared. They allow vendor extensions to be
+// passed through without losing any data.
+type Params []Param
+
+// Get returns the value of the first parameter with
+// the given key, or an empty string if there is none.
+func (p Params) Get(key string) string {
+	for _, param := range p {
+		if param.Key == key {
+			return param.Value
+		}
+	}
+	return ""
+}
+
+// without returns the parameters whose
+// keys are not any of the given keys.
+func (p Params) without(keys []string) Params {
+	var res Params
+	for _, param := range p {
+		if !hasKey(keys, param.Key) {
+			res = append(res, param)
+		}
+	}
+	return res
+}
+
+func hasKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// encode appends the parameters to the given values, returning an error
+// if any parameter collides with the known keys or can not be encoded.
+func (p Params) encode(vals []string, known []string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, param := range p {
+		if param.Key == "" || strings.ContainsAny(param.Key, "=\r\n") || strings.ContainsAny(param.Value, "\r\n") {
+			return nil, fmt.Errorf("invalid parameter '%s'", param.Key)
+		}
+		if seen[param.Key] || hasKey(known, param.Key) {
+			return nil, fmt.Errorf("duplicate parameter '%s'", param.Key)
+		}
+		seen[param.Key] = true
+		vals = append(vals, param.Key+"="+param.Value)
+	}
+	return vals, nil
+}
+
+func parseMsg(param string, raw string) (map[string]string, Params, error) {
 	if raw == "" {
-		return nil, errEmptyInput
+		return nil, nil, &ParseError{Field: param, Reason: ReasonMissing}
 	}
 
 	bytes, err := Base64.DecodeString(raw)
 	if err != nil {
-		return nil, err
+		return nil, nil, &ParseError{Field: param, Reason: ReasonMalformedBase64, Value: raw}
 	}
 
 	form := strings.Split(string(bytes), "\n")
 
 	vals := map[string]string{}
+	var params Params
 	for _, keyval := range form {
 		if keyval == "" {
 			continue
@@ -28,22 +135,24 @@ func parseMsg(raw string) (map[string]string, error) {
 
 		pair := strings.SplitN(keyval, "=", 2)
 		if len(pair) < 2 {
-			return nil, fmt.Errorf("invalid value '%s', should be in the form: key=value\\n", keyval)
+			// Should be in the form: key=value\n
+			return nil, nil, &ParseError{Field: param, Reason: ReasonMalformed, Value: keyval}
 		}
 		key := strings.TrimSpace(pair[0])
 		val := strings.TrimSpace(pair[1])
 		if _, ok := vals[key]; ok {
-			return nil, fmt.Errorf("duplicate key '%s'", key)
+			return nil, nil, &ParseError{Field: key, Reason: ReasonDuplicate, Value: val}
 		}
 		vals[key] = val
+		params = append(params, Param{Key: key, Value: val})
 	}
 
-	return vals, nil
+	return vals, params, nil
 }
 
 func parseVer(rawVer string) ([]string, error) {
-	if rawVer == "" {
-		return nil, errEmptyInput
+	if _, err := ParseVersions(rawVer); err != nil {
+		return nil, err
 	}
 	return strings.Split(rawVer, ","), nil
 }
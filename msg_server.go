@@ -19,14 +19,45 @@ type ServerMsg struct {
 	Qry string
 	URL string
 
-	// TODO: sin - Secret index
-	// TODO: suk - server unlock key
-	// TODO: ask - message text to display to user
-	// TODO: can - cancellation redirection URL
+	// Sin is the secret index the client should
+	// respond to with the ins (and pins) parameters.
+	Sin string
+	// Suk is the server unlock key previously
+	// stored for the identity that made the request.
+	Suk Identity
+	// Ask is a message and optional buttons
+	// that the client should present to the user.
+	Ask *Ask
+	// Can is the URL a client should redirect the
+	// browser to if the user cancels authentication.
+	Can string
 
-	// TODO: additional parameters
+	// Extra are any other parameters, they are encoded
+	// after the known parameters in the given order.
+	Extra Params
 }
 
+var serverKeys = []string{"ver", "nut", "tif", "qry", "url", "sin", "suk", "ask", "can"}
+
+// Ask is a prompt that the server would like the
+// client to display to the user. Up to two buttons
+// can be shown, the user's selection is returned
+// in the 'btn' parameter of the next client request.
+type Ask struct {
+	Message string
+	Buttons []AskButton
+}
+
+// AskButton is a single button shown as part of an
+// ask prompt. If URL is set, the client will open
+// it in the browser when the button is selected.
+type AskButton struct {
+	Text string
+	URL  string
+}
+
+const maxAskButtons = 2
+
 // Encode writes the server message to a string
 // ready for transmission to the client.
 func (m *ServerMsg) Encode() (string, error) {
@@ -36,13 +67,86 @@ func (m *ServerMsg) Encode() (string, error) {
 		"tif=" + strconv.Itoa(int(m.Tif)),
 		"qry=" + m.Qry,
 	}
-	if m.URL != "" {
-		vals = append(vals, "url="+m.URL)
+	vals = appendIfSet(vals, "url", m.URL)
+	vals = appendIfSet(vals, "sin", m.Sin)
+	vals = appendIfSet(vals, "suk", string(m.Suk))
+	if m.Ask != nil {
+		ask, err := m.Ask.encode()
+		if err != nil {
+			return "", err
+		}
+		vals = append(vals, "ask="+ask)
+	}
+	if m.Can != "" {
+		vals = append(vals, "can="+Base64.EncodeToString([]byte(m.Can)))
+	}
+	vals, err := m.Extra.encode(vals, serverKeys)
+	if err != nil {
+		return "", err
 	}
 	vals = append(vals, "") // Must end with a final newline
 	return Base64.EncodeToString([]byte(strings.Join(vals, "\r\n"))), nil
 }
 
+func (a *Ask) encode() (string, error) {
+	if a.Message == "" {
+		return "", errors.New("ask message must not be empty")
+	}
+	if len(a.Buttons) > maxAskButtons {
+		return "", fmt.Errorf("ask may have at most %d buttons, got %d", maxAskButtons, len(a.Buttons))
+	}
+
+	parts := []string{Base64.EncodeToString([]byte(a.Message))}
+	for _, btn := range a.Buttons {
+		if btn.Text == "" {
+			return "", errors.New("ask button text must not be empty")
+		}
+		val := btn.Text
+		if btn.URL != "" {
+			val += ";" + btn.URL
+		}
+		parts = append(parts, Base64.EncodeToString([]byte(val)))
+	}
+	return strings.Join(parts, "~"), nil
+}
+
+func parseAsk(input string) (*Ask, error) {
+	if input == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(input, "~")
+	if len(parts) > maxAskButtons+1 {
+		return nil, &ParseError{Field: "ask", Reason: ReasonMalformed, Value: input}
+	}
+
+	decoded := make([]string, len(parts))
+	for i, part := range parts {
+		bytes, err := Base64.DecodeString(part)
+		if err != nil {
+			return nil, &ParseError{Field: "ask", Reason: ReasonMalformedBase64, Value: input}
+		}
+		decoded[i] = string(bytes)
+	}
+
+	ask := &Ask{Message: decoded[0]}
+	for _, btn := range decoded[1:] {
+		pair := strings.SplitN(btn, ";", 2)
+		button := AskButton{Text: pair[0]}
+		if len(pair) == 2 {
+			button.URL = pair[1]
+		}
+		ask.Buttons = append(ask.Buttons, button)
+	}
+	return ask, nil
+}
+
+// Versions returns the versions of the
+// protocol that are supported by the server.
+func (m *ServerMsg) Versions() (Versions, error) {
+	return ParseVersions(strings.Join(m.Ver, ","))
+}
+
 // Set adds the given transaction information flag
 // to the server message.
 func (m *ServerMsg) Set(flag TIF) *ServerMsg {
@@ -64,37 +168,65 @@ func (m *ServerMsg) Is(flag TIF) bool {
 }
 
 // ParseServer decodes the base64 encoded server
-// parameter into the component parts.
+// parameter into the component parts. If the
+// message is invalid a *ParseError is returned
+// and if the server does not support any of the
+// SupportedVersions ErrNoCommonVersion is returned.
 func ParseServer(raw string) (*ServerMsg, error) {
-	vals, err := parseMsg(raw)
+	vals, params, err := parseMsg("server", raw)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(vals) < 4 {
-		return nil, errors.New("missing one or more required parameters (ver,nut,tif,qry)")
+	for _, key := range []string{"ver", "nut", "tif", "qry"} {
+		if _, ok := vals[key]; !ok {
+			return nil, &ParseError{Field: key, Reason: ReasonMissing}
+		}
 	}
 
 	ver, err := parseVer(vals["ver"])
 	if err != nil {
 		return nil, err
 	}
+	versions, _ := ParseVersions(vals["ver"])
+	if _, err := versions.Negotiate(SupportedVersions); err != nil {
+		return nil, err
+	}
 
 	tifstr := vals["tif"]
 	tif, err := strconv.Atoi(tifstr)
 	if err != nil {
-		return nil, fmt.Errorf("required value 'tif' is invalid: '%s'", tifstr)
+		return nil, &ParseError{Field: "tif", Reason: ReasonMalformed, Value: tifstr}
 	}
 
 	// TODO: Ensure nut can be decoded correctly
 	nut := Nut(vals["nut"])
 
-	// TODO: Check supported version before parsing
+	if err := validateKey("suk", vals["suk"]); err != nil {
+		return nil, err
+	}
+
+	ask, err := parseAsk(vals["ask"])
+	if err != nil {
+		return nil, err
+	}
+
+	can, err := Base64.DecodeString(vals["can"])
+	if err != nil {
+		return nil, &ParseError{Field: "can", Reason: ReasonMalformedBase64, Value: vals["can"]}
+	}
+
 	return &ServerMsg{
 		Ver: ver,
 		Nut: nut,
 		Tif: TIF(tif),
 		Qry: vals["qry"],
 		URL: vals["url"],
+		Sin: vals["sin"],
+		Suk: Identity(vals["suk"]),
+		Ask: ask,
+		Can: string(can),
+
+		Extra: params.without(serverKeys),
 	}, nil
 }
@@ -1,12 +1,15 @@
 package sqrl_test
 
 import (
+	"errors"
 	"testing"
 
 	sqrl "github.com/RaniSputnik/sqrl-go"
 	"github.com/stretchr/testify/assert"
 )
 
+const encodedServerWithAllParameters = "dmVyPTENCm51dD1mb28NCnRpZj01DQpxcnk9L3Nxcmw_bnV0PWZvbw0Kc2luPTANCnN1az1odkEwWmRDN3QzUW45YlhpRnd4cE1yMDZhQ0tZLUtISGFwcFBtd2xSSlUwDQphc2s9VEdsdWF5QjBhR2x6SUdGalkyOTFiblFffldXVnp-VEdWaGNtNGdiVzl5WlR0b2RIUndjem92TDJWNFlXMXdiR1V1WTI5dEwyaGxiSEENCmNhbj1hSFIwY0hNNkx5OWxlR0Z0Y0d4bExtTnZiUzlzYjJkcGJnDQo"
+
 func TestServerMsgEncode(t *testing.T) {
 	t.Run("EncodesValidServerMsg", func(t *testing.T) {
 		testCases := []struct {
@@ -32,6 +35,25 @@ func TestServerMsgEncode(t *testing.T) {
 				},
 				Expect: "dmVyPTENCm51dD1mb28NCnRpZj01DQpxcnk9L3Nxcmw_bnV0PWZvbw0KdXJsPWh0dHBzOi8vc3FybC5leGFtcGxlLmNvbT8xMjM0NTY3ODkNCg",
 			},
+			{
+				Input: sqrl.ServerMsg{
+					Ver: []string{sqrl.V1},
+					Nut: "foo",
+					Tif: 5,
+					Qry: "/sqrl?nut=foo",
+					Sin: "0",
+					Suk: "hvA0ZdC7t3Qn9bXiFwxpMr06aCKY-KHHappPmwlRJU0",
+					Ask: &sqrl.Ask{
+						Message: "Link this account?",
+						Buttons: []sqrl.AskButton{
+							{Text: "Yes"},
+							{Text: "Learn more", URL: "https://example.com/help"},
+						},
+					},
+					Can: "https://example.com/login",
+				},
+				Expect: encodedServerWithAllParameters,
+			},
 		}
 
 		for _, test := range testCases {
@@ -40,6 +62,31 @@ func TestServerMsgEncode(t *testing.T) {
 			assert.Equal(t, test.Expect, got)
 		}
 	})
+
+	t.Run("FailsToEncodeInvalidAsk", func(t *testing.T) {
+		testCases := []struct {
+			Name string
+			Ask  *sqrl.Ask
+		}{
+			{"EmptyMessage", &sqrl.Ask{}},
+			{"EmptyButtonText", &sqrl.Ask{
+				Message: "Hello",
+				Buttons: []sqrl.AskButton{{URL: "https://example.com"}},
+			}},
+			{"TooManyButtons", &sqrl.Ask{
+				Message: "Hello",
+				Buttons: []sqrl.AskButton{{Text: "1"}, {Text: "2"}, {Text: "3"}},
+			}},
+		}
+
+		for _, test := range testCases {
+			t.Run(test.Name, func(t *testing.T) {
+				msg := sqrl.ServerMsg{Ver: []string{sqrl.V1}, Nut: "foo", Ask: test.Ask}
+				_, err := msg.Encode()
+				assert.Error(t, err)
+			})
+		}
+	})
 }
 
 func TestServerMsgParse(t *testing.T) {
@@ -64,7 +111,7 @@ func TestServerMsgParse(t *testing.T) {
 					Nut: "QLYNwSvLFLegwE9U1FrHnA",
 					Tif: 4,
 					Qry: "/sqrl?nut=QLYNwSvLFLegwE9U1FrHnA",
-					// TODO: Sin: 0,
+					Sin: "0",
 				},
 			},
 			{
@@ -77,6 +124,25 @@ func TestServerMsgParse(t *testing.T) {
 					URL: "https://sqrl.example.com?123456789",
 				},
 			},
+			{
+				Input: encodedServerWithAllParameters,
+				Expect: sqrl.ServerMsg{
+					Ver: []string{sqrl.V1},
+					Nut: "foo",
+					Tif: 5,
+					Qry: "/sqrl?nut=foo",
+					Sin: "0",
+					Suk: "hvA0ZdC7t3Qn9bXiFwxpMr06aCKY-KHHappPmwlRJU0",
+					Ask: &sqrl.Ask{
+						Message: "Link this account?",
+						Buttons: []sqrl.AskButton{
+							{Text: "Yes"},
+							{Text: "Learn more", URL: "https://example.com/help"},
+						},
+					},
+					Can: "https://example.com/login",
+				},
+			},
 		}
 
 		for _, test := range testCases {
@@ -87,6 +153,60 @@ func TestServerMsgParse(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("ReturnsErrorWhenServerStringInvalid", func(t *testing.T) {
+		base := "ver=1\nnut=foo\ntif=5\nqry=/sqrl?nut=foo\n"
+
+		testCases := []struct {
+			Name   string
+			Input  string
+			Field  string
+			Reason sqrl.ParseReason
+		}{
+			{"MissingQry", "ver=1\nnut=foo\ntif=5\n", "qry", sqrl.ReasonMissing},
+			{"TifNotANumber", "ver=1\nnut=foo\ntif=five\nqry=/sqrl?nut=foo\n", "tif", sqrl.ReasonMalformed},
+			{"DuplicateNut", base + "nut=bar", "nut", sqrl.ReasonDuplicate},
+			{"SukTooShort", base + "suk=abc123", "suk", sqrl.ReasonInvalidKeyLength},
+			{"AskNotBase64", base + "ask=!!!", "ask", sqrl.ReasonMalformedBase64},
+			{"AskTooManyButtons", base + "ask=YQ~Yg~Yw~ZA", "ask", sqrl.ReasonMalformed},
+			{"CanNotBase64", base + "can=!!!", "can", sqrl.ReasonMalformedBase64},
+		}
+
+		for _, test := range testCases {
+			t.Run(test.Name, func(t *testing.T) {
+				_, err := sqrl.ParseServer(sqrl.Base64.EncodeToString([]byte(test.Input)))
+				var perr *sqrl.ParseError
+				if assert.True(t, errors.As(err, &perr), "Expected a *ParseError, got: %v", err) {
+					assert.Equal(t, test.Field, perr.Field)
+					assert.Equal(t, test.Reason, perr.Reason)
+				}
+			})
+		}
+	})
+
+	t.Run("ReturnsErrNoCommonVersionWhenServerVersionUnsupported", func(t *testing.T) {
+		input := "ver=2-3\nnut=foo\ntif=5\nqry=/sqrl?nut=foo\n"
+		_, err := sqrl.ParseServer(sqrl.Base64.EncodeToString([]byte(input)))
+		assert.Equal(t, sqrl.ErrNoCommonVersion, err)
+	})
+
+	t.Run("AcceptsVersionRangeIncludingSupportedVersion", func(t *testing.T) {
+		input := "ver=1-3\nnut=foo\ntif=5\nqry=/sqrl?nut=foo\n"
+		got, err := sqrl.ParseServer(sqrl.Base64.EncodeToString([]byte(input)))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"1-3"}, got.Ver)
+		}
+	})
+
+	t.Run("ReturnsErrorWhenServerStringNotBase64", func(t *testing.T) {
+		_, err := sqrl.ParseServer("!!!")
+		var perr *sqrl.ParseError
+		if assert.True(t, errors.As(err, &perr)) {
+			assert.Equal(t, "server", perr.Field)
+			assert.Equal(t, sqrl.ReasonMalformedBase64, perr.Reason)
+			assert.Equal(t, "!!!", perr.Value)
+		}
+	})
 }
 
 func TestServerMsgIs(t *testing.T) {
@@ -128,3 +248,37 @@ func TestServerMsgIs(t *testing.T) {
 		assert.Equal(t, testCase.Expect, got)
 	}
 }
+
+func TestServerMsgExtraParams(t *testing.T) {
+	t.Run("RoundTripsUnknownParameters", func(t *testing.T) {
+		input := "ver=1\r\nnut=foo\r\nvendor=abc\r\ntif=5\r\nqry=/sqrl?nut=foo\r\nexp=1\r\n"
+
+		got, err := sqrl.ParseServer(sqrl.Base64.EncodeToString([]byte(input)))
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, sqrl.Params{
+			{Key: "vendor", Value: "abc"},
+			{Key: "exp", Value: "1"},
+		}, got.Extra)
+
+		encoded, err := got.Encode()
+		if assert.NoError(t, err) {
+			reparsed, err := sqrl.ParseServer(encoded)
+			if assert.NoError(t, err) {
+				assert.Equal(t, *got, *reparsed)
+			}
+		}
+	})
+
+	t.Run("FailsToEncodeExtraParameterWithKnownKey", func(t *testing.T) {
+		msg := sqrl.ServerMsg{
+			Ver:   []string{sqrl.V1},
+			Nut:   "foo",
+			Qry:   "/sqrl?nut=foo",
+			Extra: sqrl.Params{{Key: "tif", Value: "1"}},
+		}
+		_, err := msg.Encode()
+		assert.Error(t, err)
+	})
+}
@@ -1,46 +1,164 @@
 package sqrl
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"io"
-	"sync/atomic"
+	"os"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/blowfish"
 )
 
+var (
+	// ErrNutInvalid the nut was not issued by this nutter,
+	// either because it is malformed or has been forged.
+	ErrNutInvalid = errors.New("nut invalid")
+	// ErrNutExpired the nut was issued by this nutter but
+	// is too old to be used.
+	ErrNutExpired = errors.New("nut expired")
+)
+
 // Nut is a base64, encrypted nonce that contains
 // metadata about the request that it was derived from.
 type Nut string
 
+// NutInfo is the metadata recovered from a nut
+// that was previously issued.
+type NutInfo struct {
+	Counter  uint32
+	IssuedAt time.Time
+	ClientIP string
+	Node     NodeID
+}
+
+// NodeID identifies the server that issued a nut. Servers
+// sharing nut keys (eg. replicas behind a load balancer)
+// should each use a different NodeID so that the nuts
+// they issue are guaranteed to be unique.
+type NodeID uint16
+
+// DefaultNodeID derives a NodeID from the hostname and
+// process ID of the current process. It is used when no
+// NodeID is configured, but as it is derived from a hash
+// it is not guaranteed to be unique across a cluster.
+func DefaultNodeID() NodeID {
+	hostname, _ := os.Hostname()
+	h := fnv.New32a()
+	_, _ = fmt.Fprintf(h, "%s/%d", hostname, os.Getpid())
+	sum := h.Sum32()
+	return NodeID(sum>>16) ^ NodeID(sum)
+}
+
+// NutterOption configures optional behaviour of a Nutter.
+type NutterOption func(*nutterConfig)
+
+// DefaultMaxIssuedNuts is the number of unexpired nuts the
+// nutter returned by NewNutter remembers when not configured.
+const DefaultMaxIssuedNuts = 100000
+
+type nutterConfig struct {
+	node      NodeID
+	maxIssued int
+}
+
+func newNutterConfig(opts []NutterOption) *nutterConfig {
+	config := &nutterConfig{
+		node:      DefaultNodeID(),
+		maxIssued: DefaultMaxIssuedNuts,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// WithNodeID sets the NodeID of the nutter, it is embedded in
+// the nuts issued by NewAESNutter and NewKeyringNutter. The
+// 64-bit nuts issued by NewNutter have no room for it, but it
+// is still reported in the NutInfo. Defaults to DefaultNodeID
+// if not set.
+func WithNodeID(node NodeID) NutterOption {
+	return func(c *nutterConfig) {
+		c.node = node
+	}
+}
+
+// WithMaxIssuedNuts limits the number of unexpired nuts that
+// the nutter returned by NewNutter holds in memory. Once the
+// limit is reached the oldest nuts are forgotten and reported
+// as expired. It has no effect on the other nutters, which do
+// not hold any state. Defaults to DefaultMaxIssuedNuts if not set.
+func WithMaxIssuedNuts(max int) NutterOption {
+	return func(c *nutterConfig) {
+		c.maxIssued = max
+	}
+}
+
 // Nutter generates new nuts used to issue
 // unique challenges to a SQRL client. It is
 // also used to validate nuts that were
 // previously issued.
 type Nutter interface {
-	Next() Nut
+	// Next returns a new nut for a request made
+	// from the given client IP address.
+	Next(clientIP string) Nut
+
+	// Validate returns the metadata of a nut that was
+	// previously issued. ErrNutInvalid is returned if the
+	// nut was not issued by this nutter and ErrNutExpired
+	// is returned if the nut is too old to be used.
+	Validate(nut Nut) (*NutInfo, error)
 }
 
 type blowfishNutter struct {
 	cipher  *blowfish.Cipher
+	macKey  []byte
 	counter uint32
+	expiry  time.Duration
+	node    NodeID
+
+	// The encrypted nut only holds the counter and a tag
+	// proving we issued it, so the remaining metadata is
+	// held in memory until the nut expires or maxIssued
+	// is reached.
+	issued    map[uint32]NutInfo
+	oldest    uint32
+	maxIssued int
+	sync.Mutex
 }
 
-// NewNutter creates a Nut generator
-func NewNutter() Nutter {
-	// We do not need to store the key here
-	// as we never verify the nuts encryption.
-	//
-	// We could in the future use a nutter that
-	// stored some information, allowing us to
-	// avoid unnecessary calls to the nut cache.
+// NewNutter creates a Nut generator that issues 64-bit nuts.
+// Nuts are only valid for the given expiry duration.
+//
+// The nuts are too small to hold the metadata returned by
+// Validate, so it is held in the memory of this nutter. Nuts
+// can not be validated after a restart or by another process,
+// and as the nuts are issued to unauthenticated clients, only
+// the most recent nuts are held (see WithMaxIssuedNuts). Use
+// NewAESNutter or NewKeyringNutter for replicated servers or
+// servers that must accept nuts issued before a restart.
+func NewNutter(expiry time.Duration, opts ...NutterOption) Nutter {
+	config := newNutterConfig(opts)
 	anyKey := randBytes(56)
 	cipher, err := blowfish.NewCipher(anyKey)
 	if err != nil {
 		panic(err)
 	}
 	return &blowfishNutter{
-		cipher: cipher,
+		cipher:    cipher,
+		macKey:    randBytes(32),
+		expiry:    expiry,
+		node:      config.node,
+		issued:    map[uint32]NutInfo{},
+		oldest:    1,
+		maxIssued: config.maxIssued,
 	}
 }
 
@@ -49,24 +167,93 @@ func NewNutter() Nutter {
 //
 // The Nut (think nonce) is guaranteed to be unique
 // and unpredictable to prevent replay attacks.
-func (n *blowfishNutter) Next() Nut {
+func (n *blowfishNutter) Next(clientIP string) Nut {
+	n.Lock()
+	defer n.Unlock()
+
+	now := time.Now()
+	n.removeExpired(now)
+
 	nut := make([]byte, 8)
 
-	// TODO combine this with a machine fingerprint
-	count := atomic.AddUint32(&n.counter, 1)
-	binary.LittleEndian.PutUint32(nut, count)
+	n.counter++
+	count := n.counter
+	binary.LittleEndian.PutUint32(nut[0:4], count)
+	copy(nut[4:8], n.tag(count))
 
-	noise := randBytes(4)
-	nut[4] = noise[0]
-	nut[5] = noise[1]
-	nut[6] = noise[2]
-	nut[7] = noise[3]
+	n.issued[count] = NutInfo{
+		Counter:  count,
+		IssuedAt: now,
+		ClientIP: clientIP,
+		Node:     n.node,
+	}
 
 	encryptedNut := make([]byte, 8)
 	n.cipher.Encrypt(encryptedNut, nut)
 	return Nut(Base64.EncodeToString(encryptedNut))
 }
 
+func (n *blowfishNutter) Validate(nut Nut) (*NutInfo, error) {
+	encryptedNut, err := Base64.DecodeString(string(nut))
+	if err != nil || len(encryptedNut) != 8 {
+		return nil, ErrNutInvalid
+	}
+
+	decryptedNut := make([]byte, 8)
+	n.cipher.Decrypt(decryptedNut, encryptedNut)
+	count := binary.LittleEndian.Uint32(decryptedNut[0:4])
+	if !hmac.Equal(decryptedNut[4:8], n.tag(count)) {
+		return nil, ErrNutInvalid
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	// The tag proves that we issued the counter, so a
+	// counter we no longer hold has been forgotten.
+	info, ok := n.issued[count]
+	if !ok {
+		if count > 0 && count < n.oldest {
+			return nil, ErrNutExpired
+		}
+		return nil, ErrNutInvalid
+	}
+	if time.Since(info.IssuedAt) > n.expiry {
+		return nil, ErrNutExpired
+	}
+	return &info, nil
+}
+
+// tag returns the truncated HMAC of the counter, it
+// fills the rest of the block so that forged nuts are
+// rejected even once the counter has been forgotten.
+func (n *blowfishNutter) tag(count uint32) []byte {
+	var counter [4]byte
+	binary.LittleEndian.PutUint32(counter[:], count)
+	mac := hmac.New(sha256.New, n.macKey)
+	mac.Write(counter[:])
+	return mac.Sum(nil)[:4]
+}
+
+// removeExpired forgets nuts that have expired. Nuts are
+// issued in counter order, so we only need to check the
+// oldest nuts until we find one that is still valid. The
+// oldest nuts are also forgotten if the limit is reached.
+func (n *blowfishNutter) removeExpired(now time.Time) {
+	for ; n.oldest <= n.counter; n.oldest++ {
+		issued, ok := n.issued[n.oldest]
+		if ok && now.Sub(issued.IssuedAt) <= n.expiry {
+			break
+		}
+		delete(n.issued, n.oldest)
+	}
+	// Forget the oldest nuts to make room for the
+	// next, so the memory held is always bounded.
+	for ; len(n.issued) >= n.maxIssued && n.oldest <= n.counter; n.oldest++ {
+		delete(n.issued, n.oldest)
+	}
+}
+
 func randBytes(length int) []byte {
 	noise := make([]byte, length)
 	if _, err := io.ReadFull(rand.Reader, noise); err != nil {
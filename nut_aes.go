@@ -0,0 +1,148 @@
+package sqrl
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// aesBlockSize is the size in bytes of the encrypted
+// metadata of a nut generated by the AES nutter.
+const aesBlockSize = aes.BlockSize
+
+// aesTagSize is the size in bytes of the truncated
+// HMAC-SHA256 tag that authenticates the nut.
+const aesTagSize = 8
+
+// maxClockSkew is how far in the future a nut's timestamp
+// may be before we consider the nut to be invalid.
+const maxClockSkew = time.Minute
+
+type aesNutter struct {
+	cipher  cipher.Block
+	macKey  []byte
+	counter uint32
+	expiry  time.Duration
+	node    NodeID
+}
+
+// NewAESNutter creates a Nut generator that issues nuts holding a
+// single 128-bit AES block and a 64-bit authentication tag. The key
+// must be 16, 24 or 32 bytes long, nuts are only valid for the given
+// expiry duration.
+//
+// Unlike the nutter returned by NewNutter, all of the metadata
+// of the nut (IP address, timestamp, counter, node and some entropy)
+// is encrypted into the nut itself, so no state is held in memory
+// and nuts can be validated by any nutter sharing the same key.
+// The encrypted block is authenticated with a HMAC, so forged or
+// tampered nuts are always rejected.
+//
+// Only IPv4 addresses can be recovered from the nut, for all
+// other addresses the ClientIP of the NutInfo will be empty.
+func NewAESNutter(key []byte, expiry time.Duration, opts ...NutterOption) Nutter {
+	return newAESNutter(key, expiry, newNutterConfig(opts))
+}
+
+func newAESNutter(key []byte, expiry time.Duration, config *nutterConfig) *aesNutter {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		panic(aes.KeySizeError(len(key)))
+	}
+	// Separate keys are derived for encryption and
+	// authentication so the key is never used for both.
+	block, err := aes.NewCipher(deriveNutKey(key, "sqrl nut encryption")[:len(key)])
+	if err != nil {
+		panic(err)
+	}
+	return &aesNutter{
+		cipher: block,
+		macKey: deriveNutKey(key, "sqrl nut authentication"),
+		// Starting from a random counter ensures the same nut
+		// is not issued again when the server is restarted.
+		counter: binary.LittleEndian.Uint32(randBytes(4)) >> 1,
+		expiry:  expiry,
+		node:    config.node,
+	}
+}
+
+func deriveNutKey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+func (n *aesNutter) Next(clientIP string) Nut {
+	return Nut(Base64.EncodeToString(n.next(clientIP)))
+}
+
+func (n *aesNutter) Validate(nut Nut) (*NutInfo, error) {
+	encryptedNut, err := Base64.DecodeString(string(nut))
+	if err != nil {
+		return nil, ErrNutInvalid
+	}
+	return n.validate(encryptedNut)
+}
+
+// next returns the encrypted and authenticated bytes of a new nut.
+func (n *aesNutter) next(clientIP string) []byte {
+	nut := make([]byte, aesBlockSize)
+
+	if ip := net.ParseIP(clientIP).To4(); ip != nil {
+		copy(nut[0:4], ip)
+	}
+	binary.LittleEndian.PutUint32(nut[4:8], uint32(time.Now().Unix()))
+	binary.LittleEndian.PutUint32(nut[8:12], atomic.AddUint32(&n.counter, 1))
+	binary.LittleEndian.PutUint16(nut[12:14], uint16(n.node))
+	copy(nut[14:16], randBytes(2))
+
+	encryptedNut := make([]byte, aesBlockSize, aesBlockSize+aesTagSize)
+	n.cipher.Encrypt(encryptedNut, nut)
+	return append(encryptedNut, n.tag(encryptedNut)...)
+}
+
+// validate authenticates, decrypts and validates the bytes of a nut.
+func (n *aesNutter) validate(authenticatedNut []byte) (*NutInfo, error) {
+	if len(authenticatedNut) != aesBlockSize+aesTagSize {
+		return nil, ErrNutInvalid
+	}
+	encryptedNut, tag := authenticatedNut[:aesBlockSize], authenticatedNut[aesBlockSize:]
+	if !hmac.Equal(tag, n.tag(encryptedNut)) {
+		return nil, ErrNutInvalid
+	}
+
+	decryptedNut := make([]byte, aesBlockSize)
+	n.cipher.Decrypt(decryptedNut, encryptedNut)
+
+	issuedAt := time.Unix(int64(binary.LittleEndian.Uint32(decryptedNut[4:8])), 0)
+	age := time.Since(issuedAt)
+	if age < -maxClockSkew {
+		return nil, ErrNutInvalid
+	}
+	if age > n.expiry {
+		return nil, ErrNutExpired
+	}
+
+	info := &NutInfo{
+		Counter:  binary.LittleEndian.Uint32(decryptedNut[8:12]),
+		IssuedAt: issuedAt,
+		Node:     NodeID(binary.LittleEndian.Uint16(decryptedNut[12:14])),
+	}
+	if ip := net.IP(decryptedNut[0:4]); !ip.Equal(net.IPv4zero) {
+		info.ClientIP = ip.String()
+	}
+	return info, nil
+}
+
+// tag returns the truncated HMAC of the encrypted nut.
+func (n *aesNutter) tag(encryptedNut []byte) []byte {
+	mac := hmac.New(sha256.New, n.macKey)
+	mac.Write(encryptedNut)
+	return mac.Sum(nil)[:aesTagSize]
+}
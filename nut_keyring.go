@@ -0,0 +1,73 @@
+package sqrl
+
+import (
+	"fmt"
+	"time"
+)
+
+// NutKey is a key used to encrypt and decrypt nuts. The ID
+// is embedded in every nut so that the correct key can be
+// found when the nut is validated. The Secret must be 16, 24
+// or 32 bytes long.
+type NutKey struct {
+	ID     byte
+	Secret []byte
+}
+
+// Keyring holds the keys used by a nutter. New nuts are always
+// issued with the Current key, nuts issued with any of the
+// Previous keys will continue to be accepted until they expire.
+//
+// To rotate keys, make the current key a previous key and add
+// a new current key with a different ID. Once all nuts issued
+// with a previous key have expired, it can be removed.
+type Keyring struct {
+	Current  NutKey
+	Previous []NutKey
+}
+
+type keyringNutter struct {
+	current byte
+	nutters map[byte]*aesNutter
+}
+
+// NewKeyringNutter creates a Nut generator that issues nuts
+// using the keys in the given keyring. Nuts are only valid
+// for the given expiry duration.
+//
+// Nuts are encrypted and authenticated in the same way as
+// NewAESNutter, with the ID of the key used prepended to the nut. Servers that share
+// a keyring can validate each other's nuts, allowing keys to be
+// shared between replicas and rotated without invalidating the
+// nuts that are in use.
+func NewKeyringNutter(keyring Keyring, expiry time.Duration, opts ...NutterOption) Nutter {
+	config := newNutterConfig(opts)
+	n := &keyringNutter{
+		current: keyring.Current.ID,
+		nutters: map[byte]*aesNutter{},
+	}
+	for _, key := range append([]NutKey{keyring.Current}, keyring.Previous...) {
+		if _, exists := n.nutters[key.ID]; exists {
+			panic(fmt.Sprintf("duplicate nut key id: %d", key.ID))
+		}
+		n.nutters[key.ID] = newAESNutter(key.Secret, expiry, config)
+	}
+	return n
+}
+
+func (n *keyringNutter) Next(clientIP string) Nut {
+	nut := append([]byte{n.current}, n.nutters[n.current].next(clientIP)...)
+	return Nut(Base64.EncodeToString(nut))
+}
+
+func (n *keyringNutter) Validate(nut Nut) (*NutInfo, error) {
+	decoded, err := Base64.DecodeString(string(nut))
+	if err != nil || len(decoded) < 1 {
+		return nil, ErrNutInvalid
+	}
+	nutter, ok := n.nutters[decoded[0]]
+	if !ok {
+		return nil, ErrNutInvalid
+	}
+	return nutter.validate(decoded[1:])
+}
@@ -1,23 +1,45 @@
 package sqrl_test
 
 import (
+	"crypto/rand"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	sqrl "github.com/RaniSputnik/sqrl-go"
 )
 
+const anyClientIP = "10.0.0.1"
+
+var anyAESKey = make([]byte, 16)
+
 func TestNut(t *testing.T) {
-	nutter := sqrl.NewNutter()
+	t.Run("Blowfish", func(t *testing.T) {
+		testNutter(t, sqrl.NewNutter(time.Minute))
+	})
+	t.Run("AES", func(t *testing.T) {
+		testNutter(t, sqrl.NewAESNutter(anyAESKey, time.Minute))
+	})
+	t.Run("Keyring", func(t *testing.T) {
+		testNutter(t, sqrl.NewKeyringNutter(newKeyring(1), time.Minute))
+	})
+}
 
+func testNutter(t *testing.T, nutter sqrl.Nutter) {
 	t.Run("ReturnsANonEmptyValue", func(t *testing.T) {
-		result := nutter.Next()
+		result := nutter.Next(anyClientIP)
 		assert.NotEmpty(t, result)
 	})
 
+	t.Run("ReturnsBase64EncodedStringOfAtLeast64Bits", func(t *testing.T) {
+		result := nutter.Next(anyClientIP)
+		decoded, _ := sqrl.Base64.DecodeString(string(result))
+		assert.True(t, len(decoded) >= 8, "Expected nut to be at least 8 bytes long")
+	})
+
 	t.Run("ReturnsBase64EncodedString", func(t *testing.T) {
-		result := nutter.Next()
+		result := nutter.Next(anyClientIP)
 		_, err := sqrl.Base64.DecodeString(string(result))
 		assert.NoError(t, err, "Expected nut to be base64 encoded, but got an error during decoding")
 	})
@@ -26,7 +48,7 @@ func TestNut(t *testing.T) {
 		results := map[sqrl.Nut]struct{}{}
 
 		for i := 0; i < 1000; i++ {
-			result := nutter.Next()
+			result := nutter.Next(anyClientIP)
 			if !assert.NotContainsf(t, results, result, "Found duplicate nut: '%s'", result) {
 				break
 			}
@@ -37,3 +59,270 @@ func TestNut(t *testing.T) {
 
 	// TODO: Check allows concurrent generation of nuts?
 }
+
+func TestNutValidate(t *testing.T) {
+	t.Run("Blowfish", func(t *testing.T) {
+		testNutterValidate(t, func(expiry time.Duration) sqrl.Nutter {
+			return sqrl.NewNutter(expiry)
+		})
+	})
+	t.Run("BlowfishWithNodeID", func(t *testing.T) {
+		nutter := sqrl.NewNutter(time.Minute, sqrl.WithNodeID(42))
+		info, err := nutter.Validate(nutter.Next(anyClientIP))
+		if assert.NoError(t, err) {
+			assert.Equal(t, sqrl.NodeID(42), info.Node)
+		}
+	})
+	t.Run("BlowfishForgetsOldestNutsWhenFull", func(t *testing.T) {
+		nutter := sqrl.NewNutter(time.Minute, sqrl.WithMaxIssuedNuts(2))
+		first := nutter.Next(anyClientIP)
+		second := nutter.Next(anyClientIP)
+		third := nutter.Next(anyClientIP)
+
+		_, err := nutter.Validate(first)
+		assert.Equal(t, sqrl.ErrNutExpired, err)
+		_, err = nutter.Validate(second)
+		assert.NoError(t, err)
+		_, err = nutter.Validate(third)
+		assert.NoError(t, err)
+	})
+	t.Run("BlowfishRejectsForgedNutsBelowTheOldestCounter", func(t *testing.T) {
+		// Half of all counters are below the oldest nut held
+		nutter := sqrl.NewNutterFromCounter(time.Minute, 1<<31)
+		for i := 0; i < 100; i++ {
+			forged := sqrl.Nut(sqrl.Base64.EncodeToString(randomKey()[:8]))
+			_, err := nutter.Validate(forged)
+			assert.Equal(t, sqrl.ErrNutInvalid, err, "Expected forged nut '%s' to be invalid", forged)
+		}
+	})
+	t.Run("AES", func(t *testing.T) {
+		testNutterValidate(t, func(expiry time.Duration) sqrl.Nutter {
+			return sqrl.NewAESNutter(randomKey(), expiry)
+		})
+	})
+	t.Run("Keyring", func(t *testing.T) {
+		testNutterValidate(t, func(expiry time.Duration) sqrl.Nutter {
+			return sqrl.NewKeyringNutter(newKeyring(1), expiry)
+		})
+	})
+}
+
+func testNutterValidate(t *testing.T, newNutter func(expiry time.Duration) sqrl.Nutter) {
+	nutter := newNutter(time.Minute)
+
+	t.Run("ReturnsInfoForIssuedNut", func(t *testing.T) {
+		before := time.Now()
+		nut := nutter.Next(anyClientIP)
+
+		info, err := nutter.Validate(nut)
+		if assert.NoError(t, err) {
+			assert.Equal(t, anyClientIP, info.ClientIP)
+			assert.Equal(t, sqrl.DefaultNodeID(), info.Node)
+			assert.NotZero(t, info.Counter)
+			assert.False(t, info.IssuedAt.Before(before.Truncate(time.Second)), "Expected nut to be issued after test started")
+		}
+	})
+
+	t.Run("ReturnsIncreasingCounters", func(t *testing.T) {
+		first, _ := nutter.Validate(nutter.Next(anyClientIP))
+		second, _ := nutter.Validate(nutter.Next(anyClientIP))
+		if assert.NotNil(t, first) && assert.NotNil(t, second) {
+			assert.True(t, second.Counter > first.Counter)
+		}
+	})
+
+	t.Run("ReturnsInvalidForMalformedNut", func(t *testing.T) {
+		cases := []sqrl.Nut{"", "notbase64!!@!@£$", "abc123", "rNRqu8olcWLAPaDvsL4b6owTVfryjzbre3hWHWnNTrK_hIS_KgIDFt2eBDc"}
+		for _, nut := range cases {
+			_, err := nutter.Validate(nut)
+			assert.Equal(t, sqrl.ErrNutInvalid, err, "Expected nut '%s' to be invalid", nut)
+		}
+	})
+
+	t.Run("ReturnsInvalidForNutFromAnotherNutter", func(t *testing.T) {
+		otherNutter := newNutter(time.Minute)
+		nut := otherNutter.Next(anyClientIP)
+
+		_, err := nutter.Validate(nut)
+		assert.Equal(t, sqrl.ErrNutInvalid, err)
+	})
+
+	t.Run("ReturnsExpiredForOldNut", func(t *testing.T) {
+		veryShortExpiry := time.Millisecond
+		nutterWithShortExpiry := newNutter(veryShortExpiry)
+		nut := nutterWithShortExpiry.Next(anyClientIP)
+
+		time.Sleep(veryShortExpiry * 3)
+
+		_, err := nutterWithShortExpiry.Validate(nut)
+		assert.Equal(t, sqrl.ErrNutExpired, err)
+	})
+
+	t.Run("ReturnsExpiredForOldNutAfterItIsForgotten", func(t *testing.T) {
+		veryShortExpiry := time.Millisecond
+		nutterWithShortExpiry := newNutter(veryShortExpiry)
+		nut := nutterWithShortExpiry.Next(anyClientIP)
+
+		time.Sleep(veryShortExpiry * 3)
+		_ = nutterWithShortExpiry.Next(anyClientIP)
+
+		_, err := nutterWithShortExpiry.Validate(nut)
+		assert.Equal(t, sqrl.ErrNutExpired, err)
+	})
+}
+
+func TestAESNut(t *testing.T) {
+	t.Run("Returns128BitBlockAnd64BitTag", func(t *testing.T) {
+		nutter := sqrl.NewAESNutter(anyAESKey, time.Minute)
+		decoded, err := sqrl.Base64.DecodeString(string(nutter.Next(anyClientIP)))
+		if assert.NoError(t, err) {
+			assert.Len(t, decoded, 24)
+		}
+	})
+
+	t.Run("ReturnsInvalidForTamperedNut", func(t *testing.T) {
+		nutter := sqrl.NewAESNutter(anyAESKey, time.Minute)
+		decoded, _ := sqrl.Base64.DecodeString(string(nutter.Next(anyClientIP)))
+
+		for i := range decoded {
+			tampered := append([]byte(nil), decoded...)
+			tampered[i] ^= 0x01
+			_, err := nutter.Validate(sqrl.Nut(sqrl.Base64.EncodeToString(tampered)))
+			assert.Equal(t, sqrl.ErrNutInvalid, err, "Expected nut tampered at byte %d to be invalid", i)
+		}
+	})
+
+	t.Run("DoesNotRepeatNutsAfterRestart", func(t *testing.T) {
+		key := randomKey()
+		before := sqrl.NewAESNutter(key, time.Minute, sqrl.WithNodeID(1)).Next(anyClientIP)
+		after := sqrl.NewAESNutter(key, time.Minute, sqrl.WithNodeID(1)).Next(anyClientIP)
+		assert.NotEqual(t, before, after)
+	})
+
+	t.Run("ValidatesNutsFromAnotherNutterWithTheSameKey", func(t *testing.T) {
+		key := randomKey()
+		nut := sqrl.NewAESNutter(key, time.Minute).Next(anyClientIP)
+
+		info, err := sqrl.NewAESNutter(key, time.Minute).Validate(nut)
+		if assert.NoError(t, err) {
+			assert.Equal(t, anyClientIP, info.ClientIP)
+		}
+	})
+
+	t.Run("DoesNotRecoverIPv6Addresses", func(t *testing.T) {
+		nutter := sqrl.NewAESNutter(anyAESKey, time.Minute)
+		nut := nutter.Next("2001:db8::1")
+
+		info, err := nutter.Validate(nut)
+		if assert.NoError(t, err) {
+			assert.Empty(t, info.ClientIP)
+		}
+	})
+
+	t.Run("ReportsTheNodeThatIssuedTheNut", func(t *testing.T) {
+		key := randomKey()
+		nodeA := sqrl.NewAESNutter(key, time.Minute, sqrl.WithNodeID(1))
+		nodeB := sqrl.NewAESNutter(key, time.Minute, sqrl.WithNodeID(2))
+
+		info, err := nodeA.Validate(nodeB.Next(anyClientIP))
+		if assert.NoError(t, err) {
+			assert.Equal(t, sqrl.NodeID(2), info.Node)
+		}
+	})
+
+	t.Run("DoesNotRepeatNutsAcrossNodesSharingAKey", func(t *testing.T) {
+		key := randomKey()
+		nodeA := sqrl.NewAESNutter(key, time.Minute, sqrl.WithNodeID(1))
+		nodeB := sqrl.NewAESNutter(key, time.Minute, sqrl.WithNodeID(2))
+
+		results := map[sqrl.Nut]struct{}{}
+		for i := 0; i < 1000; i++ {
+			for _, nut := range []sqrl.Nut{nodeA.Next(anyClientIP), nodeB.Next(anyClientIP)} {
+				if !assert.NotContainsf(t, results, nut, "Found duplicate nut: '%s'", nut) {
+					return
+				}
+				results[nut] = struct{}{}
+			}
+		}
+	})
+
+	t.Run("PanicsForInvalidKeyLength", func(t *testing.T) {
+		assert.Panics(t, func() {
+			sqrl.NewAESNutter(make([]byte, 7), time.Minute)
+		})
+	})
+}
+
+func newKeyring(id byte) sqrl.Keyring {
+	return sqrl.Keyring{
+		Current: sqrl.NutKey{ID: id, Secret: randomKey()},
+	}
+}
+
+func randomKey() []byte {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func TestKeyringNut(t *testing.T) {
+	t.Run("ValidatesNutsAfterRestart", func(t *testing.T) {
+		keyring := newKeyring(1)
+		nut := sqrl.NewKeyringNutter(keyring, time.Minute).Next(anyClientIP)
+
+		_, err := sqrl.NewKeyringNutter(keyring, time.Minute).Validate(nut)
+		assert.NoError(t, err)
+	})
+
+	t.Run("ValidatesNutsIssuedWithPreviousKey", func(t *testing.T) {
+		oldKeyring := newKeyring(1)
+		nut := sqrl.NewKeyringNutter(oldKeyring, time.Minute).Next(anyClientIP)
+
+		rotatedKeyring := newKeyring(2)
+		rotatedKeyring.Previous = []sqrl.NutKey{oldKeyring.Current}
+		info, err := sqrl.NewKeyringNutter(rotatedKeyring, time.Minute).Validate(nut)
+		if assert.NoError(t, err) {
+			assert.Equal(t, anyClientIP, info.ClientIP)
+		}
+	})
+
+	t.Run("IssuesNutsWithCurrentKey", func(t *testing.T) {
+		oldKeyring := newKeyring(1)
+		rotatedKeyring := newKeyring(2)
+		rotatedKeyring.Previous = []sqrl.NutKey{oldKeyring.Current}
+		nut := sqrl.NewKeyringNutter(rotatedKeyring, time.Minute).Next(anyClientIP)
+
+		_, err := sqrl.NewKeyringNutter(oldKeyring, time.Minute).Validate(nut)
+		assert.Equal(t, sqrl.ErrNutInvalid, err)
+	})
+
+	t.Run("RejectsNutsIssuedWithRemovedKey", func(t *testing.T) {
+		nut := sqrl.NewKeyringNutter(newKeyring(1), time.Minute).Next(anyClientIP)
+
+		_, err := sqrl.NewKeyringNutter(newKeyring(2), time.Minute).Validate(nut)
+		assert.Equal(t, sqrl.ErrNutInvalid, err)
+	})
+
+	t.Run("RejectsNutsWithSameKeyIDButDifferentSecret", func(t *testing.T) {
+		nut := sqrl.NewKeyringNutter(newKeyring(1), time.Minute).Next(anyClientIP)
+
+		_, err := sqrl.NewKeyringNutter(newKeyring(1), time.Minute).Validate(nut)
+		assert.Equal(t, sqrl.ErrNutInvalid, err)
+	})
+
+	t.Run("PanicsForDuplicateKeyIDs", func(t *testing.T) {
+		keyring := newKeyring(1)
+		keyring.Previous = []sqrl.NutKey{newKeyring(1).Current}
+		assert.Panics(t, func() {
+			sqrl.NewKeyringNutter(keyring, time.Minute)
+		})
+	})
+}
+
+func TestDefaultNodeID(t *testing.T) {
+	t.Run("IsStableForTheCurrentProcess", func(t *testing.T) {
+		assert.Equal(t, sqrl.DefaultNodeID(), sqrl.DefaultNodeID())
+	})
+}
@@ -0,0 +1,340 @@
+package s4
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/RaniSputnik/sqrl-go/enscrypt"
+)
+
+const (
+	keyLength  = 32
+	ivLength   = 12
+	saltLength = 16
+	tagLength  = 16
+
+	accessPlaintextLength   = 45
+	accessLength            = accessPlaintextLength + 2*keyLength + tagLength
+	rescuePlaintextLength   = 25
+	rescueLength            = rescuePlaintextLength + keyLength + tagLength
+	previousPlaintextLength = 6
+
+	// MaxPrevious is the maximum number of previous
+	// identity unlock keys held by the previous block.
+	MaxPrevious = 4
+)
+
+// ErrDecrypt the block could not be decrypted, either
+// the password or key is incorrect or the block is corrupt.
+var ErrDecrypt = errors.New("incorrect password or corrupt block")
+
+// AccessBlock is the password protected block (type 1), holding
+// the Identity Master Key (IMK) and Identity Lock Key (ILK).
+//
+// All of the settings are authenticated along with the keys,
+// so they must not be changed once the block has been sealed.
+type AccessBlock struct {
+	IV         [ivLength]byte
+	Salt       [saltLength]byte
+	LogN       uint8
+	Iterations uint32
+	// Options are the option flags of the identity.
+	Options uint16
+	// HintLength is the number of password characters
+	// used as a quick pass to re-authenticate the user.
+	HintLength uint8
+	// PWVerifySeconds is how long the password
+	// should take to verify on this machine.
+	PWVerifySeconds uint8
+	// IdleTimeout is the number of minutes after
+	// which the quick pass should be discarded.
+	IdleTimeout uint16
+
+	// Encrypted are the encrypted IMK and ILK,
+	// followed by the verification tag.
+	Encrypted []byte
+}
+
+// NewAccessBlock creates an access block protecting the
+// given keys with the password, using the reference client
+// defaults for the settings of the block.
+func NewAccessBlock(password, imk, ilk []byte, iterations uint32) (*AccessBlock, error) {
+	b := &AccessBlock{
+		LogN:            enscrypt.LogN,
+		Iterations:      iterations,
+		HintLength:      4,
+		PWVerifySeconds: 5,
+		IdleTimeout:     15,
+	}
+	if err := b.Seal(password, imk, ilk); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Seal encrypts the IMK and ILK with a key derived from the
+// password. A new IV and salt are generated for the block.
+func (b *AccessBlock) Seal(password, imk, ilk []byte) error {
+	if len(imk) != keyLength || len(ilk) != keyLength {
+		return errors.New("keys must be 32 bytes")
+	}
+	if err := randomize(b.IV[:], b.Salt[:]); err != nil {
+		return err
+	}
+	key, err := enscrypt.Key(password, b.Salt[:], b.LogN, int(b.Iterations))
+	if err != nil {
+		return err
+	}
+	plaintext := append(append([]byte(nil), imk...), ilk...)
+	b.Encrypted, err = seal(key, b.IV[:], plaintext, b.plaintext())
+	return err
+}
+
+// Open decrypts the IMK and ILK using the password.
+func (b *AccessBlock) Open(password []byte) (imk, ilk []byte, err error) {
+	key, err := enscrypt.Key(password, b.Salt[:], b.LogN, int(b.Iterations))
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, err := open(key, b.IV[:], b.Encrypted, b.plaintext())
+	if err != nil || len(keys) != 2*keyLength {
+		return nil, nil, ErrDecrypt
+	}
+	return keys[:keyLength], keys[keyLength:], nil
+}
+
+func (b *AccessBlock) plaintext() []byte {
+	res := appendBlockHeader(nil, accessLength, TypeAccess)
+	res = appendUint16(res, accessPlaintextLength)
+	res = append(res, b.IV[:]...)
+	res = append(res, b.Salt[:]...)
+	res = append(res, b.LogN)
+	res = appendUint32(res, b.Iterations)
+	res = appendUint16(res, b.Options)
+	res = append(res, b.HintLength, b.PWVerifySeconds)
+	return appendUint16(res, b.IdleTimeout)
+}
+
+func (b *AccessBlock) encode() []byte {
+	return append(b.plaintext(), b.Encrypted...)
+}
+
+func parseAccess(block []byte) (*AccessBlock, error) {
+	if len(block) != accessLength {
+		return nil, errors.New("invalid length")
+	}
+	if binary.LittleEndian.Uint16(block[4:]) != accessPlaintextLength {
+		return nil, errors.New("invalid plaintext length")
+	}
+	b := &AccessBlock{
+		LogN:            block[34],
+		Iterations:      binary.LittleEndian.Uint32(block[35:]),
+		Options:         binary.LittleEndian.Uint16(block[39:]),
+		HintLength:      block[41],
+		PWVerifySeconds: block[42],
+		IdleTimeout:     binary.LittleEndian.Uint16(block[43:]),
+		Encrypted:       append([]byte(nil), block[accessPlaintextLength:]...),
+	}
+	copy(b.IV[:], block[6:])
+	copy(b.Salt[:], block[18:])
+	return b, nil
+}
+
+// RescueBlock is the rescue code protected block (type 2),
+// holding the Identity Unlock Key (IUK).
+type RescueBlock struct {
+	Salt       [saltLength]byte
+	LogN       uint8
+	Iterations uint32
+
+	// Encrypted is the encrypted IUK,
+	// followed by the verification tag.
+	Encrypted []byte
+}
+
+// NewRescueBlock creates a rescue block protecting
+// the IUK with the given 24 digit rescue code.
+func NewRescueBlock(rescueCode string, iuk []byte, iterations uint32) (*RescueBlock, error) {
+	b := &RescueBlock{
+		LogN:       enscrypt.LogN,
+		Iterations: iterations,
+	}
+	if err := b.Seal(rescueCode, iuk); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Seal encrypts the IUK with a key derived from the
+// rescue code. A new salt is generated for the block.
+func (b *RescueBlock) Seal(rescueCode string, iuk []byte) error {
+	if len(iuk) != keyLength {
+		return errors.New("iuk must be 32 bytes")
+	}
+	if err := randomize(b.Salt[:]); err != nil {
+		return err
+	}
+	key, err := enscrypt.Key([]byte(rescueCode), b.Salt[:], b.LogN, int(b.Iterations))
+	if err != nil {
+		return err
+	}
+	b.Encrypted, err = seal(key, zeroIV[:], iuk, b.plaintext())
+	return err
+}
+
+// Open decrypts the IUK using the rescue code.
+func (b *RescueBlock) Open(rescueCode string) (iuk []byte, err error) {
+	key, err := enscrypt.Key([]byte(rescueCode), b.Salt[:], b.LogN, int(b.Iterations))
+	if err != nil {
+		return nil, err
+	}
+	iuk, err = open(key, zeroIV[:], b.Encrypted, b.plaintext())
+	if err != nil || len(iuk) != keyLength {
+		return nil, ErrDecrypt
+	}
+	return iuk, nil
+}
+
+func (b *RescueBlock) plaintext() []byte {
+	res := appendBlockHeader(nil, rescueLength, TypeRescue)
+	res = append(res, b.Salt[:]...)
+	res = append(res, b.LogN)
+	return appendUint32(res, b.Iterations)
+}
+
+func (b *RescueBlock) encode() []byte {
+	return append(b.plaintext(), b.Encrypted...)
+}
+
+func parseRescue(block []byte) (*RescueBlock, error) {
+	if len(block) != rescueLength {
+		return nil, errors.New("invalid length")
+	}
+	b := &RescueBlock{
+		LogN:       block[20],
+		Iterations: binary.LittleEndian.Uint32(block[21:]),
+		Encrypted:  append([]byte(nil), block[rescuePlaintextLength:]...),
+	}
+	copy(b.Salt[:], block[4:])
+	return b, nil
+}
+
+// PreviousBlock is the block (type 3) holding the Identity Unlock
+// Keys of up to four previous identities, encrypted with the IMK.
+type PreviousBlock struct {
+	// Count is the number of previous keys held.
+	Count uint16
+
+	// Encrypted are the encrypted previous IUKs, most
+	// recent first, followed by the verification tag.
+	Encrypted []byte
+}
+
+// NewPreviousBlock creates a previous block holding the given
+// previous IUKs, most recent first, encrypted with the IMK.
+func NewPreviousBlock(imk []byte, iuks [][]byte) (*PreviousBlock, error) {
+	b := &PreviousBlock{}
+	if err := b.Seal(imk, iuks); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Seal encrypts the previous IUKs with the IMK.
+func (b *PreviousBlock) Seal(imk []byte, iuks [][]byte) error {
+	if len(iuks) < 1 || len(iuks) > MaxPrevious {
+		return fmt.Errorf("between 1 and %d previous keys are required", MaxPrevious)
+	}
+	var plaintext []byte
+	for _, iuk := range iuks {
+		if len(iuk) != keyLength {
+			return errors.New("iuk must be 32 bytes")
+		}
+		plaintext = append(plaintext, iuk...)
+	}
+	b.Count = uint16(len(iuks))
+	var err error
+	b.Encrypted, err = seal(imk, zeroIV[:], plaintext, b.plaintext())
+	return err
+}
+
+// Open decrypts the previous IUKs using the IMK.
+func (b *PreviousBlock) Open(imk []byte) (iuks [][]byte, err error) {
+	plaintext, err := open(imk, zeroIV[:], b.Encrypted, b.plaintext())
+	if err != nil || len(plaintext) != int(b.Count)*keyLength {
+		return nil, ErrDecrypt
+	}
+	for i := 0; i < len(plaintext); i += keyLength {
+		iuks = append(iuks, plaintext[i:i+keyLength])
+	}
+	return iuks, nil
+}
+
+func (b *PreviousBlock) length() int {
+	return previousPlaintextLength + int(b.Count)*keyLength + tagLength
+}
+
+func (b *PreviousBlock) plaintext() []byte {
+	res := appendBlockHeader(nil, b.length(), TypePrevious)
+	return appendUint16(res, b.Count)
+}
+
+func (b *PreviousBlock) encode() ([]byte, error) {
+	if len(b.Encrypted) != b.length()-previousPlaintextLength {
+		return nil, errors.New("previous block does not match count")
+	}
+	return append(b.plaintext(), b.Encrypted...), nil
+}
+
+func parsePrevious(block []byte) (*PreviousBlock, error) {
+	if len(block) < previousPlaintextLength {
+		return nil, errors.New("invalid length")
+	}
+	b := &PreviousBlock{Count: binary.LittleEndian.Uint16(block[4:])}
+	if b.Count < 1 || b.Count > MaxPrevious || len(block) != b.length() {
+		return nil, errors.New("invalid length")
+	}
+	b.Encrypted = append([]byte(nil), block[previousPlaintextLength:]...)
+	return b, nil
+}
+
+// zeroIV is used by the blocks whose keys
+// are never used to encrypt more than once.
+var zeroIV [ivLength]byte
+
+func seal(key, iv, plaintext, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nil, iv, plaintext, additional), nil
+}
+
+func open(key, iv, ciphertext, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, iv, ciphertext, additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomize(bufs ...[]byte) error {
+	for _, buf := range bufs {
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Package s4 reads and writes the S4 (Secure Storage System for SQRL)
+// format that SQRL clients use to store and exchange identities.
+//
+// An S4 container is a header followed by a sequence of blocks, each
+// prefixed with it's length and type. The binary form starts with the
+// header "sqrldata", the text form with "SQRLDATA" followed by the
+// blocks encoded as base64url.
+//
+// Reference: https://www.grc.com/sqrl/storage.htm
+package s4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	sqrl "github.com/RaniSputnik/sqrl-go"
+)
+
+const (
+	// BinaryHeader is the header of the binary form.
+	BinaryHeader = "sqrldata"
+	// TextHeader is the header of the base64url text form.
+	TextHeader = "SQRLDATA"
+
+	blockHeaderLength = 4
+)
+
+// BlockType identifies the contents of a block.
+type BlockType uint16
+
+const (
+	// TypeAccess is the password protected block holding
+	// the identity master key and identity lock key.
+	TypeAccess = BlockType(1)
+	// TypeRescue is the rescue code protected block
+	// holding the identity unlock key.
+	TypeRescue = BlockType(2)
+	// TypePrevious is the block holding the identity
+	// unlock keys of up to four previous identities.
+	TypePrevious = BlockType(3)
+)
+
+var (
+	// ErrInvalidHeader the data does not start with a S4 header.
+	ErrInvalidHeader = errors.New("invalid s4 header")
+	// ErrMissingAccess the container does not include
+	// the password protected block.
+	ErrMissingAccess = errors.New("missing access block")
+)
+
+// BlockError is returned when a block can not be read.
+type BlockError struct {
+	Type   BlockType
+	Offset int
+	Reason string
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("invalid block type %d at offset %d: %s", e.Type, e.Offset, e.Reason)
+}
+
+// Block is a block that is not understood by this package.
+// It is kept so that it can be written back unchanged.
+type Block struct {
+	Type BlockType
+	Data []byte
+}
+
+// Identity is the contents of a S4 container. Only the access block is
+// required, the rescue and previous blocks are nil if not present.
+type Identity struct {
+	Access   *AccessBlock
+	Rescue   *RescueBlock
+	Previous *PreviousBlock
+
+	// Unknown are blocks of any other type, in the order they
+	// appeared. They are encoded after the known blocks.
+	Unknown []Block
+}
+
+// Parse reads an identity in either the binary or text form.
+func Parse(data []byte) (*Identity, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte(BinaryHeader)):
+		return parseBlocks(data[len(BinaryHeader):])
+	case bytes.HasPrefix(data, []byte(TextHeader)):
+		text := strings.TrimSpace(string(data[len(TextHeader):]))
+		blocks, err := sqrl.Base64.DecodeString(text)
+		if err != nil {
+			return nil, ErrInvalidHeader
+		}
+		return parseBlocks(blocks)
+	default:
+		return nil, ErrInvalidHeader
+	}
+}
+
+func parseBlocks(data []byte) (*Identity, error) {
+	id := &Identity{}
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < blockHeaderLength {
+			return nil, &BlockError{Offset: offset, Reason: "truncated"}
+		}
+		length := int(binary.LittleEndian.Uint16(data[offset:]))
+		typ := BlockType(binary.LittleEndian.Uint16(data[offset+2:]))
+		if length < blockHeaderLength || offset+length > len(data) {
+			return nil, &BlockError{Type: typ, Offset: offset, Reason: "invalid length"}
+		}
+		block := data[offset : offset+length]
+
+		var err error
+		switch typ {
+		case TypeAccess:
+			if id.Access != nil {
+				err = errors.New("duplicate")
+			} else {
+				id.Access, err = parseAccess(block)
+			}
+		case TypeRescue:
+			if id.Rescue != nil {
+				err = errors.New("duplicate")
+			} else {
+				id.Rescue, err = parseRescue(block)
+			}
+		case TypePrevious:
+			if id.Previous != nil {
+				err = errors.New("duplicate")
+			} else {
+				id.Previous, err = parsePrevious(block)
+			}
+		default:
+			id.Unknown = append(id.Unknown, Block{
+				Type: typ,
+				Data: append([]byte(nil), block[blockHeaderLength:]...),
+			})
+		}
+		if err != nil {
+			return nil, &BlockError{Type: typ, Offset: offset, Reason: err.Error()}
+		}
+		offset += length
+	}
+
+	if id.Access == nil {
+		return nil, ErrMissingAccess
+	}
+	return id, nil
+}
+
+// Encode writes the identity in the binary form.
+func (id *Identity) Encode() ([]byte, error) {
+	blocks, err := id.encodeBlocks()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(BinaryHeader), blocks...), nil
+}
+
+// EncodeText writes the identity in the text form.
+func (id *Identity) EncodeText() (string, error) {
+	blocks, err := id.encodeBlocks()
+	if err != nil {
+		return "", err
+	}
+	return TextHeader + sqrl.Base64.EncodeToString(blocks), nil
+}
+
+func (id *Identity) encodeBlocks() ([]byte, error) {
+	if id.Access == nil {
+		return nil, ErrMissingAccess
+	}
+
+	res := id.Access.encode()
+	if id.Rescue != nil {
+		res = append(res, id.Rescue.encode()...)
+	}
+	if id.Previous != nil {
+		previous, err := id.Previous.encode()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, previous...)
+	}
+	for _, block := range id.Unknown {
+		length := blockHeaderLength + len(block.Data)
+		if length > 0xffff {
+			return nil, fmt.Errorf("block type %d is too large", block.Type)
+		}
+		res = appendBlockHeader(res, length, block.Type)
+		res = append(res, block.Data...)
+	}
+	return res, nil
+}
+
+func appendBlockHeader(dst []byte, length int, typ BlockType) []byte {
+	dst = appendUint16(dst, uint16(length))
+	return appendUint16(dst, uint16(typ))
+}
+
+func appendUint16(dst []byte, v uint16) []byte {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	return append(dst, b[:]...)
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(dst, b[:]...)
+}
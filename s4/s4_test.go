@@ -0,0 +1,189 @@
+package s4_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/RaniSputnik/sqrl-go/s4"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	password   = []byte("correct horse battery staple")
+	rescueCode = "119949307128845611298464"
+	imk        = bytes.Repeat([]byte{0x01}, 32)
+	ilk        = bytes.Repeat([]byte{0x02}, 32)
+	iuk        = bytes.Repeat([]byte{0x03}, 32)
+	previous   = [][]byte{bytes.Repeat([]byte{0x04}, 32), bytes.Repeat([]byte{0x05}, 32)}
+)
+
+func TestRoundTrip(t *testing.T) {
+	id := newTestIdentity(t)
+
+	binaryForm, err := id.Encode()
+	if !assert.NoError(t, err) {
+		return
+	}
+	textForm, err := id.EncodeText()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, strings.HasPrefix(textForm, s4.TextHeader))
+
+	for name, data := range map[string][]byte{"Binary": binaryForm, "Text": []byte(textForm)} {
+		t.Run(name, func(t *testing.T) {
+			got, err := s4.Parse(data)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, id, got)
+
+			gotIMK, gotILK, err := got.Access.Open(password)
+			if assert.NoError(t, err) {
+				assert.Equal(t, imk, gotIMK)
+				assert.Equal(t, ilk, gotILK)
+			}
+			gotIUK, err := got.Rescue.Open(rescueCode)
+			if assert.NoError(t, err) {
+				assert.Equal(t, iuk, gotIUK)
+			}
+			gotPrevious, err := got.Previous.Open(imk)
+			if assert.NoError(t, err) {
+				assert.Equal(t, previous, gotPrevious)
+			}
+		})
+	}
+}
+
+func TestEncodeLayout(t *testing.T) {
+	id := newTestIdentity(t)
+	data, err := id.Encode()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, s4.BinaryHeader, string(data[:8]))
+	blocks := data[8:]
+
+	expected := []struct {
+		Length uint16
+		Type   s4.BlockType
+	}{
+		{125, s4.TypeAccess},
+		{73, s4.TypeRescue},
+		{86, s4.TypePrevious},
+	}
+	for _, want := range expected {
+		assert.Equal(t, want.Length, binary.LittleEndian.Uint16(blocks))
+		assert.Equal(t, want.Type, s4.BlockType(binary.LittleEndian.Uint16(blocks[2:])))
+		blocks = blocks[want.Length:]
+	}
+	assert.Empty(t, blocks)
+}
+
+func TestOpenFailsWithWrongSecret(t *testing.T) {
+	id := newTestIdentity(t)
+
+	_, _, err := id.Access.Open([]byte("wrong password"))
+	assert.Equal(t, s4.ErrDecrypt, err)
+
+	_, err = id.Rescue.Open("000000000000000000000000")
+	assert.Equal(t, s4.ErrDecrypt, err)
+
+	_, err = id.Previous.Open(ilk)
+	assert.Equal(t, s4.ErrDecrypt, err)
+}
+
+func TestOpenFailsWhenSettingsAreTampered(t *testing.T) {
+	id := newTestIdentity(t)
+	id.Access.HintLength = 0
+
+	_, _, err := id.Access.Open(password)
+	assert.Equal(t, s4.ErrDecrypt, err)
+}
+
+func TestParsePreservesUnknownBlocks(t *testing.T) {
+	id := newTestIdentity(t)
+	id.Rescue = nil
+	id.Previous = nil
+	id.Unknown = []s4.Block{{Type: 42, Data: []byte("vendor data")}}
+
+	data, err := id.Encode()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	got, err := s4.Parse(data)
+	if assert.NoError(t, err) {
+		assert.Nil(t, got.Rescue)
+		assert.Nil(t, got.Previous)
+		assert.Equal(t, id.Unknown, got.Unknown)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	id := newTestIdentity(t)
+	valid, _ := id.Encode()
+	access := valid[8 : 8+125]
+
+	t.Run("InvalidHeader", func(t *testing.T) {
+		_, err := s4.Parse([]byte("notsqrl" + string(access)))
+		assert.Equal(t, s4.ErrInvalidHeader, err)
+	})
+
+	t.Run("InvalidText", func(t *testing.T) {
+		_, err := s4.Parse([]byte(s4.TextHeader + "!!!"))
+		assert.Equal(t, s4.ErrInvalidHeader, err)
+	})
+
+	t.Run("MissingAccessBlock", func(t *testing.T) {
+		_, err := s4.Parse([]byte(s4.BinaryHeader))
+		assert.Equal(t, s4.ErrMissingAccess, err)
+	})
+
+	t.Run("TruncatedBlock", func(t *testing.T) {
+		_, err := s4.Parse(valid[:len(valid)-1])
+		var berr *s4.BlockError
+		if assert.True(t, errors.As(err, &berr), "Expected a *BlockError, got: %v", err) {
+			assert.Equal(t, s4.TypePrevious, berr.Type)
+		}
+	})
+
+	t.Run("DuplicateAccessBlock", func(t *testing.T) {
+		data := append([]byte(s4.BinaryHeader), access...)
+		data = append(data, access...)
+		_, err := s4.Parse(data)
+		var berr *s4.BlockError
+		if assert.True(t, errors.As(err, &berr), "Expected a *BlockError, got: %v", err) {
+			assert.Equal(t, s4.TypeAccess, berr.Type)
+			assert.Equal(t, 125, berr.Offset)
+		}
+	})
+
+	t.Run("InvalidAccessLength", func(t *testing.T) {
+		data := append([]byte(s4.BinaryHeader), access[:124]...)
+		binary.LittleEndian.PutUint16(data[8:], 124)
+		_, err := s4.Parse(data)
+		var berr *s4.BlockError
+		assert.True(t, errors.As(err, &berr), "Expected a *BlockError, got: %v", err)
+	})
+}
+
+func newTestIdentity(t *testing.T) *s4.Identity {
+	access, err := s4.NewAccessBlock(password, imk, ilk, 1)
+	if err != nil {
+		t.Fatalf("Failed to create access block: %v", err)
+	}
+	rescue, err := s4.NewRescueBlock(rescueCode, iuk, 1)
+	if err != nil {
+		t.Fatalf("Failed to create rescue block: %v", err)
+	}
+	prev, err := s4.NewPreviousBlock(imk, previous)
+	if err != nil {
+		t.Fatalf("Failed to create previous block: %v", err)
+	}
+	return &s4.Identity{Access: access, Rescue: rescue, Previous: prev}
+}
@@ -11,10 +11,11 @@ const (
 	// Scheme is sqrl: used in SQRL URI's
 	Scheme = "sqrl"
 
-	// TODO what to do about qrl://
+	// QRLScheme is qrl: used in SQRL URI's that the
+	// client should answer over plain http rather
+	// than https.
 	// https://www.grc.com/sqrl/protocol.htm
-	// A SQRL client will only interpret a URL link beginning
-	// with “sqrl://” or “qrl://” as a valid SQRL link.
+	QRLScheme = "qrl"
 )
 
 // Cmd are the different commands a SQRL client
@@ -11,6 +11,7 @@ import (
 	"os"
 	"time"
 
+	sqrl "github.com/RaniSputnik/sqrl-go"
 	"github.com/RaniSputnik/sqrl-go/ssp"
 )
 
@@ -38,6 +39,9 @@ func main() {
 	sspServer := ssp.Configure(todoKey, "http://localhost:8080/callback").
 		WithAuthentication(serverToServerProtection).
 		WithLogger(log.New(os.Stdout, "SSP: ", 0)).
+		WithNutter(sqrl.NewAESNutter(todoKey, ssp.DefaultNutExpiry)).
+		WithHosts("localhost:8080").
+		WithSFN("SQRL Go Example").
 		// TODO: bit lame that this cli.sqrl is both hardcoded
 		// in ssp and configured here. Should we only provide
 		// the /sqrl part here? Or should cli.sqrl be moved out
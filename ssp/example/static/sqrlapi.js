@@ -13,18 +13,20 @@ window.SQRL.inject = function(config) {
     var localhostRoot = 'http://localhost:25519/';	// the SQRL client listening URL root
     var imageProbeUrl = 'http://www.rebindtest.com/open.gif';
     Date.now = Date.now || function () { return (+new Date()) };	// add old browser Date.now() support
-    var sqrlNut;
+    var sqrlNut, sqrlUrl, sqrlSfn;
 
     var sqrlBaseUrl = config.api
     // TODO: Make these endpoints configurable
     var nutEp = sqrlBaseUrl + '/nut.sqrl'
-    // TODO: This test for whether the endpoint is absolute is likely insufficient
-    var sqrlEp = sqrlBaseUrl.indexOf('http') > -1 ? 
-        sqrlBaseUrl.replace('https:', 'sqrl:') + '/cli.sqrl' :
-        // TODO: Clean this up - will break if config.api does not start with /
-        'sqrl://' + window.location.host + sqrlBaseUrl + '/cli.sqrl';
     var qrEp = sqrlBaseUrl + '/qr.png'
 
+    // Decodes the base64url (no padding) values sent by the server
+    function base64UrlDecode(encoded) {
+        var base64 = (encoded || '').replace(/-/g, '+').replace(/_/g, '/');
+        while (base64.length % 4) base64 += '=';
+        return window.atob(base64);
+    }
+
     //============================================================================//
     // This function is invoked once when the page is loaded. It queries the SQRL //
     // API to obtain a unique browser-session-cookie-based NUT, which it plugs in //
@@ -44,7 +46,12 @@ window.SQRL.inject = function(config) {
                         }
                     }
                     sqrlNut = vals['nut'];
-                    if (x = document.getElementById("sqrl")) x.href = sqrlEp + '?nut=' + sqrlNut;
+                    sqrlUrl = decodeURIComponent(vals['url']); // the SQRL link is built by the server
+                    sqrlSfn = base64UrlDecode(vals['sfn']); // the server friendly name included in the link
+                    if (x = document.getElementById("sqrl")) {
+                        x.href = sqrlUrl;
+                        x.title = 'Sign in to ' + sqrlSfn + ' with SQRL';
+                    }
                     if (x = document.getElementById("qrimg")) x.src = qrEp + '?nut=' + sqrlNut;
                     pollForNextPage();	// start our next page checking
                 } else {
@@ -101,7 +108,7 @@ window.SQRL.inject = function(config) {
     //============================================================================//
     gifProbe.onload = function () {  // define our load-success function
         // base64url-encode our CPS-jump URL. This replaces '/' with '_' and '+' with '-' and removes all trailing '='s
-        var encodedSqrlUrl = window.btoa(sqrlBaseUrl).replace(/\//, "_").replace(/\+/, "-").replace(/=+$/, "");
+        var encodedSqrlUrl = window.btoa(sqrlUrl).replace(/\//g, "_").replace(/\+/g, "-").replace(/=+$/, "");
         document.location.href = localhostRoot + encodedSqrlUrl;
     };
 
@@ -0,0 +1,9 @@
+package ssp
+
+import "time"
+
+// NewMemoryStoreWithNutExpiry creates a memory store that
+// forgets consumed nuts after the given expiry.
+func NewMemoryStoreWithNutExpiry(nutExpiry time.Duration) Store {
+	return newMemoryStore(nutExpiry)
+}
@@ -34,12 +34,21 @@ func (s *Server) Handler() http.Handler {
 func (s *Server) NutHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
 
-	nut := s.Nut()
+	nut := s.Nut(ClientIP(r))
 	s.logger.Printf("Generated nut: %s", nut)
 
+	loginURL, err := s.loginURL(r, nut)
+	if err != nil {
+		s.logger.Printf("Failed to generate login URL: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	formValues := make(url.Values)
 	formValues.Add("nut", string(nut))
 	formValues.Add("can", sqrl.Base64.EncodeToString([]byte(r.Header.Get("Referer"))))
+	formValues.Add("sfn", sqrl.Base64.EncodeToString([]byte(s.friendlyName())))
+	formValues.Add("url", loginURL.String())
 
 	if _, err := w.Write([]byte(formValues.Encode())); err != nil {
 		s.logger.Printf("Nut write unsuccessful: %v", err)
@@ -66,12 +75,11 @@ func (s *Server) QRCodeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	params := make(url.Values)
-	params.Add("nut", nut)
-	loginURL := url.URL{
-		Scheme:   "sqrl",
-		Host:     requestDomain(r),
-		RawQuery: params.Encode(),
+	loginURL, err := s.loginURL(r, sqrl.Nut(nut))
+	if err != nil {
+		s.logger.Printf("Failed to generate login URL: %v", err)
+		w.WriteHeader(http.StatusInternalServerError) // TODO: default error image
+		return
 	}
 
 	bytes, err := qrcode.Encode(loginURL.String(), qrcode.Medium, size)
@@ -98,8 +106,21 @@ func atoiWithDefault(val string, def int) int {
 	}
 }
 
-func requestDomain(r *http.Request) string {
-	return r.Host
+// loginURL returns the SQRL link that the user
+// should follow to sign in with the given nut.
+func (s *Server) loginURL(r *http.Request, nut sqrl.Nut) (*sqrl.URL, error) {
+	host, err := s.linkHost(r)
+	if err != nil {
+		return nil, err
+	}
+	return &sqrl.URL{
+		Scheme: sqrl.Scheme,
+		Host:   host,
+		Path:   s.clientEndpoint,
+		Ext:    s.ext,
+		Nut:    nut,
+		SFN:    s.friendlyName(),
+	}, nil
 }
 
 func getTokenRedirectURL(server *Server, token Token) string {
@@ -1,15 +1,20 @@
 package ssp_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ed25519"
 
 	sqrl "github.com/RaniSputnik/sqrl-go"
+	"github.com/RaniSputnik/sqrl-go/ssp"
 )
 
 const emptyBody = ""
@@ -17,17 +22,16 @@ const validServer = "dmVyPTENCm51dD11c2pxZmgzdFJoYWdHbjkyN0RZRmpRDQp0aWY9NA0KcXJ
 
 // Sourced from grc.com SQRL diagnostic site
 // https://www.grc.com/sqrl/logsample.htm
-const invalidQuerySignature = "client=dmVyPTENCmNtZD1xdWVyeQ0KaWRrPVpIa2RQTDM0eWFhSmR5aUtVT1F1SS1zMmtqei1uSGcwVU5RMFpBcjZlZHMNCg&server=c3FybDovL3d3dy5ncmMuY29tL3Nxcmw_bnV0PUNYam9xNVJla3FTNUQ1d3V5QktMUlEmc2ZuPVIxSkQ&ids=invalid"
-
 const validQueryBody = "client=dmVyPTENCmNtZD1xdWVyeQ0KaWRrPVpIa2RQTDM0eWFhSmR5aUtVT1F1SS1zMmtqei1uSGcwVU5RMFpBcjZlZHMNCg&server=c3FybDovL3d3dy5ncmMuY29tL3Nxcmw_bnV0PUNYam9xNVJla3FTNUQ1d3V5QktMUlEmc2ZuPVIxSkQ&ids=JqY1dMvWFunVSykecky3pM21KtW67gegPxcEpiA2obUzb1igxrLrEj5hI9QPZb8dIAnn8TtYSpPj4mRFFqNcAA"
-const validQueryNut = "CXjoq5RekqS5D5wuyBKLRQ"
 
-const validIdentBody = "client=dmVyPTENCmNtZD1pZGVudA0KaWRrPVpIa2RQTDM0eWFhSmR5aUtVT1F1SS1zMmtqei1uSGcwVU5RMFpBcjZlZHMNCg&server=dmVyPTENCm51dD01aHFaS3VIeXE1dDZ5Mmlmb1czd1B3DQp0aWY9NQ0KcXJ5PS9zcXJsP251dD01aHFaS3VIeXE1dDZ5Mmlmb1czd1B3DQo&ids=z__MvVTGpeDLLPj3O9QLNrkcvsk_8iuipu-DWalCfQWuP1xXom3HW1MhXNOYYhYiO2Kx2qMgT3D0uze3hdYLDg"
-const validIdentNut = "5hqZKuHyq5t6y2ifoW3wPw"
+// This nut was issued by grc.com rather than the
+// server under test, so it will never be accepted.
+const unknownNut = "CXjoq5RekqS5D5wuyBKLRQ"
 
 func TestAuthenticateReturnsClientErrorWhenContentTypeIsNotFormEncoded(t *testing.T) {
-	h := anyServer().ClientHandler(NewStore(), anyTokenExchange())
-	w, r := setupAuthenticate(validQueryNut, emptyBody)
+	s := anyServer()
+	h := s.ClientHandler(NewStore(), anyTokenExchange())
+	w, r := setupAuthenticate(s.Nut(anyClientIP), emptyBody)
 	r.Header.Set("Content-Type", "application/json")
 
 	h.ServeHTTP(w, r)
@@ -39,13 +43,331 @@ func TestAuthenticateReturnsClientErrorWhenContentTypeIsNotFormEncoded(t *testin
 	}
 }
 
-// TODO: Invalid Server Param
+func TestAuthenticateReturnsClientFailureWhenNutWasNotIssuedByServer(t *testing.T) {
+	store := NewStore().ReturnsKnownIdentity()
+	h := anyServer().ClientHandler(store, anyTokenExchange())
+	w, r := setupAuthenticate(unknownNut, validQueryBody)
+
+	h.ServeHTTP(w, r)
+
+	got, err := sqrl.ParseServer(w.Body.String())
+	if assert.NoError(t, err) {
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFClientFailure))
+		assert.False(t, got.Is(sqrl.TIFCurrentIDMatch))
+	}
+	assert.Empty(t, store.Func.GetFirstTransaction.CalledWith.Nut, "Expected store not to be used")
+}
+
+func TestAuthenticateReturnsTransientErrorWhenNutHasExpired(t *testing.T) {
+	veryShortExpiry := time.Millisecond
+	s := anyServer().WithNutter(sqrl.NewAESNutter(make([]byte, 16), veryShortExpiry))
+	store := NewStore().ReturnsKnownIdentity()
+	h := s.ClientHandler(store, anyTokenExchange())
+	w, r := setupAuthenticate(s.Nut(anyClientIP), validQueryBody)
+
+	time.Sleep(veryShortExpiry * 3)
+	h.ServeHTTP(w, r)
+
+	got, err := sqrl.ParseServer(w.Body.String())
+	if assert.NoError(t, err) {
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFTransientError))
+		assert.False(t, got.Is(sqrl.TIFClientFailure))
+	}
+	assert.Empty(t, store.Func.GetFirstTransaction.CalledWith.Nut, "Expected store not to be used")
+}
+
+func TestAuthenticateAcceptsNutsFromConfiguredNutter(t *testing.T) {
+	s := anyServer().WithNutter(sqrl.NewAESNutter(make([]byte, 16), time.Minute))
+	h := s.ClientHandler(NewStore().ReturnsKnownIdentity(), anyTokenExchange())
+	nut := s.Nut(anyClientIP)
+	w, r := setupAuthenticate(nut, newTestIdentity().body(sqrl.CmdQuery, nut, false))
+
+	h.ServeHTTP(w, r)
+
+	got, err := sqrl.ParseServer(w.Body.String())
+	if assert.NoError(t, err) {
+		assert.False(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFCurrentIDMatch))
+	}
+}
+
+func TestAuthenticateReturnsClientFailureWhenNutAlreadyUsed(t *testing.T) {
+	alice := newTestIdentity()
+	store := NewStore().ReturnsUnknownIdentity()
+	store.Func.ConsumeNut.Returns.Err = ssp.ErrNutAlreadyUsed
+
+	s := anyServer()
+	nut := s.Nut(anyClientIP)
+	w, r := setupAuthenticate(nut, alice.body(sqrl.CmdIdent, nut, false))
+	s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+	got, err := sqrl.ParseServer(w.Body.String())
+	if assert.NoError(t, err) {
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFClientFailure))
+		assert.False(t, got.Is(sqrl.TIFTransientError))
+	}
+	assert.Equal(t, nut, store.Func.ConsumeNut.CalledWith.Nut)
+	assert.Empty(t, store.Func.CreateUser.CalledWith.Idk, "Expected no user to be created")
+	assert.Nil(t, store.Func.SaveTransaction.CalledWith.Transaction, "Expected no transaction to be saved")
+}
+
+func TestAuthenticateRejectsReplayedRequest(t *testing.T) {
+	alice := newTestIdentity()
+	store := ssp.NewMemoryStore()
+
+	s := anyServer()
+	h := s.ClientHandler(store, anyTokenExchange())
+	nut := s.Nut(anyClientIP)
+	body := alice.body(sqrl.CmdIdent, nut, false)
+
+	w, r := setupAuthenticate(nut, body)
+	h.ServeHTTP(w, r)
+	first, err := sqrl.ParseServer(w.Body.String())
+	if assert.NoError(t, err) {
+		assert.False(t, first.Is(sqrl.TIFCommandFailed))
+	}
+
+	w, r = setupAuthenticate(nut, body)
+	h.ServeHTTP(w, r)
+	replayed, err := sqrl.ParseServer(w.Body.String())
+	if assert.NoError(t, err) {
+		assert.True(t, replayed.Is(sqrl.TIFCommandFailed))
+		assert.True(t, replayed.Is(sqrl.TIFClientFailure))
+		assert.False(t, replayed.Is(sqrl.TIFTransientError))
+		assert.False(t, replayed.Is(sqrl.TIFCurrentIDMatch))
+	}
+}
+
+func TestAuthenticateSavesTheExactResponseSent(t *testing.T) {
+	alice := newTestIdentity()
+	store := NewStore().ReturnsUnknownIdentity()
+
+	s := anyServer()
+	nut := s.Nut(anyClientIP)
+	w, r := setupAuthenticate(nut, alice.body(sqrl.CmdQuery, nut, false))
+	s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+	got, err := sqrl.ParseServer(w.Body.String())
+	if assert.NoError(t, err) {
+		assert.Equal(t, got.Nut, store.Func.SaveResponse.CalledWith.Nut)
+	}
+	assert.Equal(t, w.Body.String(), store.Func.SaveResponse.CalledWith.Response)
+	assert.Equal(t, nut, store.Func.GetResponse.CalledWith.Nut)
+}
+
+func TestAuthenticateFollowUpRequest(t *testing.T) {
+	alice := newTestIdentity()
+
+	query := func(s *ssp.Server, h http.Handler) (sqrl.Nut, string) {
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdQuery, nut, false))
+		h.ServeHTTP(w, r)
+		got, _ := sqrl.ParseServer(w.Body.String())
+		return got.Nut, w.Body.String()
+	}
+
+	t.Run("SucceedsWhenServerParamIsTheLastResponse", func(t *testing.T) {
+		s := anyServer()
+		h := s.ClientHandler(ssp.NewMemoryStore(), anyTokenExchange())
+		nextNut, lastResponse := query(s, h)
+
+		w, r := setupAuthenticate(nextNut, alice.bodyWithServer(sqrl.CmdIdent, lastResponse, false))
+		h.ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.False(t, got.Is(sqrl.TIFCommandFailed))
+			assert.True(t, got.Is(sqrl.TIFIPMatch))
+		}
+	})
+
+	t.Run("FailsWhenServerParamHasBeenTamperedWith", func(t *testing.T) {
+		s := anyServer()
+		h := s.ClientHandler(ssp.NewMemoryStore(), anyTokenExchange())
+		nextNut, lastResponse := query(s, h)
+
+		tampered, _ := sqrl.ParseServer(lastResponse)
+		tampered.Set(sqrl.TIFCurrentIDMatch)
+		tamperedResponse, _ := tampered.Encode()
+
+		w, r := setupAuthenticate(nextNut, alice.bodyWithServer(sqrl.CmdIdent, tamperedResponse, false))
+		h.ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.True(t, got.Is(sqrl.TIFCommandFailed))
+			assert.True(t, got.Is(sqrl.TIFClientFailure))
+		}
+	})
+
+	t.Run("FailsWhenServerParamIsTheOriginalURL", func(t *testing.T) {
+		s := anyServer()
+		h := s.ClientHandler(ssp.NewMemoryStore(), anyTokenExchange())
+		nextNut, _ := query(s, h)
+
+		w, r := setupAuthenticate(nextNut, alice.body(sqrl.CmdIdent, nextNut, false))
+		h.ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.True(t, got.Is(sqrl.TIFCommandFailed))
+			assert.True(t, got.Is(sqrl.TIFClientFailure))
+		}
+	})
+}
+
+func TestAuthenticateChecksTheServerURL(t *testing.T) {
+	alice := newTestIdentity()
+
+	authenticate := func(s *ssp.Server, serverURL string) *sqrl.ServerMsg {
+		nut := s.Nut(anyClientIP)
+		serverURL = strings.Replace(serverURL, "{nut}", string(nut), 1)
+		serverURL = strings.Replace(serverURL, "{sfn}", b64("example.com"), 1)
+		server := b64(serverURL)
+		w, r := setupAuthenticate(nut, alice.bodyWithServer(sqrl.CmdQuery, server, false))
+		s.ClientHandler(NewStore().ReturnsUnknownIdentity(), anyTokenExchange()).ServeHTTP(w, r)
+		got, _ := sqrl.ParseServer(w.Body.String())
+		return got
+	}
+
+	t.Run("AcceptsURLForConfiguredHost", func(t *testing.T) {
+		s := anyServer().WithHosts("example.com", "www.example.com")
+		got := authenticate(s, "sqrl://www.example.com/cli.sqrl?nut={nut}&sfn={sfn}")
+		assert.False(t, got.Is(sqrl.TIFCommandFailed))
+	})
+
+	t.Run("RejectsURLForOtherHost", func(t *testing.T) {
+		s := anyServer().WithHosts("www.example.com")
+		got := authenticate(s, "sqrl://example.com/cli.sqrl?nut={nut}&sfn={sfn}")
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFClientFailure))
+	})
+
+	t.Run("DoesNotTrustTheRequestHost", func(t *testing.T) {
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		server := b64(strings.Replace("sqrl://evil.example.com/cli.sqrl?nut={nut}&sfn="+b64("example.com"), "{nut}", string(nut), 1))
+		w, r := setupAuthenticate(nut, alice.bodyWithServer(sqrl.CmdQuery, server, false))
+		r.Host = "evil.example.com"
+		s.ClientHandler(NewStore().ReturnsUnknownIdentity(), anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.True(t, got.Is(sqrl.TIFCommandFailed))
+			assert.True(t, got.Is(sqrl.TIFClientFailure))
+		}
+	})
+
+	t.Run("RejectsAllURLsWithoutConfiguredHosts", func(t *testing.T) {
+		s := ssp.Configure(make([]byte, 16), "http://example.com/auth/callback")
+		got := authenticate(s, "sqrl://example.com/cli.sqrl?nut={nut}&sfn={sfn}")
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+	})
+
+	t.Run("RejectsURLForOtherClientEndpoint", func(t *testing.T) {
+		s := anyServer().WithClientEndpoint("/sqrl/cli.sqrl")
+		got := authenticate(s, "sqrl://example.com/cli.sqrl?nut={nut}&sfn={sfn}")
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFClientFailure))
+	})
+
+	t.Run("AcceptsURLWithConfiguredExt", func(t *testing.T) {
+		s := anyServer().WithClientEndpoint("/alice/cli.sqrl").WithExt(6)
+		got := authenticate(s, "sqrl://example.com/alice/cli.sqrl?nut={nut}&x=6&sfn={sfn}")
+		assert.False(t, got.Is(sqrl.TIFCommandFailed))
+	})
+
+	t.Run("RejectsURLWithOtherExt", func(t *testing.T) {
+		s := anyServer().WithClientEndpoint("/alice/cli.sqrl")
+		got := authenticate(s, "sqrl://example.com/alice/cli.sqrl?nut={nut}&x=6&sfn={sfn}")
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFClientFailure))
+	})
+
+	t.Run("AcceptsURLWithConfiguredSFN", func(t *testing.T) {
+		s := anyServer().WithSFN("Example")
+		got := authenticate(s, "sqrl://example.com/cli.sqrl?nut={nut}&sfn="+b64("Example"))
+		assert.False(t, got.Is(sqrl.TIFCommandFailed))
+	})
+
+	t.Run("RejectsURLWithOtherSFN", func(t *testing.T) {
+		s := anyServer().WithSFN("Example")
+		got := authenticate(s, "sqrl://example.com/cli.sqrl?nut={nut}&sfn="+b64("Evil"))
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFClientFailure))
+	})
+
+	t.Run("RejectsURLWithoutSFN", func(t *testing.T) {
+		got := authenticate(anyServer(), "sqrl://example.com/cli.sqrl?nut={nut}")
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFClientFailure))
+	})
+}
+
+func TestAuthenticateReturnsFunctionNotSupportedForUnknownCommand(t *testing.T) {
+	store := NewStore().ReturnsKnownIdentity()
+	s := anyServer()
+	nut := s.Nut(anyClientIP)
+	w, r := setupAuthenticate(nut, newTestIdentity().body(sqrl.Cmd("login"), nut, false))
+	s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+	got, err := sqrl.ParseServer(w.Body.String())
+	if assert.NoError(t, err) {
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFFunctionNotSupported))
+		assert.False(t, got.Is(sqrl.TIFClientFailure))
+	}
+}
+
+func TestAuthenticateNegotiatesVersion(t *testing.T) {
+	alice := newTestIdentity()
+
+	authenticate := func(ver ...string) *sqrl.ServerMsg {
+		c := sqrl.ClientMsg{Ver: ver, Cmd: sqrl.CmdQuery, Idk: alice.idk}
+		client, _ := c.Encode()
+
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		server := b64((&sqrl.URL{Host: "example.com", Path: "/cli.sqrl", Nut: nut, SFN: "example.com"}).String())
+		form := url.Values{}
+		form.Set("client", client)
+		form.Set("server", server)
+		form.Set("ids", sqrl.Base64.EncodeToString(ed25519.Sign(alice.idkKey, []byte(client+server))))
+
+		w, r := setupAuthenticate(nut, form.Encode())
+		s.ClientHandler(NewStore().ReturnsKnownIdentity(), anyTokenExchange()).ServeHTTP(w, r)
+		got, _ := sqrl.ParseServer(w.Body.String())
+		return got
+	}
+
+	t.Run("RepliesWithSupportedVersions", func(t *testing.T) {
+		got := authenticate("1")
+		assert.Equal(t, sqrl.SupportedVersions.Strings(), got.Ver)
+	})
+
+	t.Run("AcceptsClientWithCommonVersion", func(t *testing.T) {
+		got := authenticate("1-3")
+		assert.False(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFCurrentIDMatch))
+	})
+
+	t.Run("RejectsClientWithoutCommonVersion", func(t *testing.T) {
+		got := authenticate("2", "3")
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFClientFailure))
+		assert.False(t, got.Is(sqrl.TIFCurrentIDMatch))
+	})
+}
 
 // TODO: Invalid Server Param
 
 func TestAuthenticateReturnsClientFailureWhenClientParamIsMissing(t *testing.T) {
-	h := anyServer().ClientHandler(NewStore(), anyTokenExchange())
-	w, r := setupAuthenticate(validQueryNut, fmt.Sprintf("server=%s", validServer))
+	s := anyServer()
+	h := s.ClientHandler(NewStore(), anyTokenExchange())
+	w, r := setupAuthenticate(s.Nut(anyClientIP), fmt.Sprintf("server=%s", validServer))
 	h.ServeHTTP(w, r)
 
 	got, err := sqrl.ParseServer(w.Body.String())
@@ -67,11 +389,12 @@ func TestAuthenticateReturnsClientFailureWhenClientStringIsInvalid(t *testing.T)
 		{"VerComesSecond", b64("cmd=query\nver=1")},
 	}
 
-	h := anyServer().ClientHandler(NewStore(), anyTokenExchange())
+	s := anyServer()
+	h := s.ClientHandler(NewStore(), anyTokenExchange())
 
 	for _, test := range cases {
 		t.Run(test.Name, func(t *testing.T) {
-			w, r := setupAuthenticate(validQueryNut, fmt.Sprintf("server=%s&client=%s", validServer, test.Input))
+			w, r := setupAuthenticate(s.Nut(anyClientIP), fmt.Sprintf("server=%s&client=%s", validServer, test.Input))
 			h.ServeHTTP(w, r)
 
 			got, err := sqrl.ParseServer(w.Body.String())
@@ -84,8 +407,10 @@ func TestAuthenticateReturnsClientFailureWhenClientStringIsInvalid(t *testing.T)
 }
 
 func TestAuthenticateReturnsCurrentIDMatchWhenIDIsKnown(t *testing.T) {
-	w, r := setupAuthenticate(validQueryNut, validQueryBody)
-	h := anyServer().ClientHandler(NewStore().ReturnsKnownIdentity(), anyTokenExchange())
+	s := anyServer()
+	nut := s.Nut(anyClientIP)
+	w, r := setupAuthenticate(nut, newTestIdentity().body(sqrl.CmdQuery, nut, false))
+	h := s.ClientHandler(NewStore().ReturnsKnownIdentity(), anyTokenExchange())
 
 	h.ServeHTTP(w, r)
 
@@ -96,8 +421,10 @@ func TestAuthenticateReturnsCurrentIDMatchWhenIDIsKnown(t *testing.T) {
 }
 
 func TestAuthenticateReturnsNoIDMatchWhenIDIsUnknown(t *testing.T) {
-	w, r := setupAuthenticate(validQueryNut, validQueryBody)
-	h := anyServer().ClientHandler(NewStore().ReturnsUnknownIdentity(), anyTokenExchange())
+	s := anyServer()
+	nut := s.Nut(anyClientIP)
+	w, r := setupAuthenticate(nut, newTestIdentity().body(sqrl.CmdQuery, nut, false))
+	h := s.ClientHandler(NewStore().ReturnsUnknownIdentity(), anyTokenExchange())
 
 	h.ServeHTTP(w, r)
 
@@ -108,8 +435,12 @@ func TestAuthenticateReturnsNoIDMatchWhenIDIsUnknown(t *testing.T) {
 }
 
 func TestAuthenticateReturnsClientErrorWhenSignatureInvalid(t *testing.T) {
-	w, r := setupAuthenticate(validQueryNut, invalidQuerySignature)
-	h := anyServer().ClientHandler(NewStore(), anyTokenExchange())
+	s := anyServer()
+	nut := s.Nut(anyClientIP)
+	body, _ := url.ParseQuery(newTestIdentity().body(sqrl.CmdQuery, nut, false))
+	body.Set("ids", "invalid")
+	w, r := setupAuthenticate(nut, body.Encode())
+	h := s.ClientHandler(NewStore(), anyTokenExchange())
 
 	h.ServeHTTP(w, r)
 
@@ -119,10 +450,27 @@ func TestAuthenticateReturnsClientErrorWhenSignatureInvalid(t *testing.T) {
 	}
 }
 
+func TestAuthenticateRejectsInvalidSignatureBeforeUsingStore(t *testing.T) {
+	s := anyServer()
+	nut := s.Nut(anyClientIP)
+	body, _ := url.ParseQuery(newTestIdentity().body(sqrl.CmdQuery, nut, false))
+	body.Set("ids", "invalid")
+	w, r := setupAuthenticate(nut, body.Encode())
+	store := NewStore()
+	h := s.ClientHandler(store, anyTokenExchange())
+
+	h.ServeHTTP(w, r)
+
+	assert.Empty(t, store.Func.GetFirstTransaction.CalledWith.Nut, "Expected first transaction not to be retrieved")
+	assert.Empty(t, store.Func.GetResponse.CalledWith.Nut, "Expected last response not to be retrieved")
+}
+
 func TestAuthenticateCallsStoreSaveIdentSuccessWhenIdentSuccessful(t *testing.T) {
 	store := NewStore().ReturnsKnownIdentity()
-	w, r := setupAuthenticate(validIdentNut, validIdentBody)
-	h := anyServer().ClientHandler(store, anyTokenExchange())
+	s := anyServer()
+	nut := s.Nut(anyClientIP)
+	w, r := setupAuthenticate(nut, newTestIdentity().body(sqrl.CmdIdent, nut, false))
+	h := s.ClientHandler(store, anyTokenExchange())
 
 	h.ServeHTTP(w, r)
 
@@ -131,6 +479,325 @@ func TestAuthenticateCallsStoreSaveIdentSuccessWhenIdentSuccessful(t *testing.T)
 	assert.NotEmpty(t, store.Func.SaveIdentSuccess.CalledWith.Token)
 }
 
+func TestAuthenticateDisable(t *testing.T) {
+	alice := newTestIdentity()
+
+	t.Run("DisablesKnownUser", func(t *testing.T) {
+		store := NewStore().ReturnsKnownUser(alice.user())
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdDisable, nut, false))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.False(t, got.Is(sqrl.TIFCommandFailed))
+			assert.True(t, got.Is(sqrl.TIFSQRLDisabled))
+		}
+		if assert.NotNil(t, store.Func.SaveUser.CalledWith.User) {
+			assert.True(t, store.Func.SaveUser.CalledWith.User.Disabled)
+		}
+	})
+
+	t.Run("FailsForUnknownUser", func(t *testing.T) {
+		store := NewStore().ReturnsUnknownIdentity()
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdDisable, nut, false))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		}
+		assert.Nil(t, store.Func.SaveUser.CalledWith.User)
+	})
+
+	t.Run("SetsSQRLDisabledForDisabledUser", func(t *testing.T) {
+		user := alice.user()
+		user.Disabled = true
+		store := NewStore().ReturnsKnownUser(user)
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdQuery, nut, false))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.True(t, got.Is(sqrl.TIFCurrentIDMatch))
+			assert.True(t, got.Is(sqrl.TIFSQRLDisabled))
+		}
+	})
+
+	t.Run("RefusesIdentForDisabledUser", func(t *testing.T) {
+		user := alice.user()
+		user.Disabled = true
+		store := NewStore().ReturnsKnownUser(user)
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdIdent, nut, false))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.True(t, got.Is(sqrl.TIFCommandFailed))
+			assert.True(t, got.Is(sqrl.TIFSQRLDisabled))
+		}
+		assert.Empty(t, store.Func.SaveIdentSuccess.CalledWith.Token)
+	})
+}
+
+func TestAuthenticateEnable(t *testing.T) {
+	alice := newTestIdentity()
+
+	t.Run("FailsWithoutUnlockRequestSignature", func(t *testing.T) {
+		user := alice.user()
+		user.Disabled = true
+		store := NewStore().ReturnsKnownUser(user)
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdEnable, nut, false))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.True(t, got.Is(sqrl.TIFCommandFailed))
+			assert.True(t, got.Is(sqrl.TIFClientFailure))
+			assert.True(t, got.Is(sqrl.TIFSQRLDisabled))
+		}
+		assert.Nil(t, store.Func.SaveUser.CalledWith.User)
+	})
+
+	t.Run("FailsWhenUnlockKeyDoesNotMatch", func(t *testing.T) {
+		user := alice.user()
+		user.Disabled = true
+		user.Vuk = newTestIdentity().vuk
+		store := NewStore().ReturnsKnownUser(user)
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdEnable, nut, true))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		}
+		assert.Nil(t, store.Func.SaveUser.CalledWith.User)
+	})
+
+	t.Run("EnablesDisabledUser", func(t *testing.T) {
+		user := alice.user()
+		user.Disabled = true
+		store := NewStore().ReturnsKnownUser(user)
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdEnable, nut, true))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.False(t, got.Is(sqrl.TIFCommandFailed))
+			assert.False(t, got.Is(sqrl.TIFSQRLDisabled))
+		}
+		if assert.NotNil(t, store.Func.SaveUser.CalledWith.User) {
+			assert.False(t, store.Func.SaveUser.CalledWith.User.Disabled)
+		}
+	})
+}
+
+func TestAuthenticateRemove(t *testing.T) {
+	alice := newTestIdentity()
+
+	t.Run("FailsWithoutUnlockRequestSignature", func(t *testing.T) {
+		store := NewStore().ReturnsKnownUser(alice.user())
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdRemove, nut, false))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.True(t, got.Is(sqrl.TIFCommandFailed))
+			assert.True(t, got.Is(sqrl.TIFClientFailure))
+		}
+		assert.Empty(t, store.Func.DeleteUser.CalledWith.Id)
+	})
+
+	t.Run("RemovesKnownUser", func(t *testing.T) {
+		store := NewStore().ReturnsKnownUser(alice.user())
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdRemove, nut, true))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.False(t, got.Is(sqrl.TIFCommandFailed))
+			assert.False(t, got.Is(sqrl.TIFCurrentIDMatch))
+		}
+		assert.Equal(t, "alice", store.Func.DeleteUser.CalledWith.Id)
+	})
+}
+
+func TestAuthenticateIdentStoresUnlockKeys(t *testing.T) {
+	alice := newTestIdentity()
+	store := NewStore().ReturnsUnknownIdentity()
+	store.Func.CreateUser.Returns.User = &ssp.User{Id: "alice", Idk: alice.idk}
+
+	s := anyServer()
+	nut := s.Nut(anyClientIP)
+	w, r := setupAuthenticate(nut, alice.body(sqrl.CmdIdent, nut, false))
+	s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+	got, err := sqrl.ParseServer(w.Body.String())
+	if assert.NoError(t, err) {
+		assert.False(t, got.Is(sqrl.TIFCommandFailed))
+	}
+	if assert.NotNil(t, store.Func.SaveUser.CalledWith.User) {
+		assert.Equal(t, alice.suk, store.Func.SaveUser.CalledWith.User.Suk)
+		assert.Equal(t, alice.vuk, store.Func.SaveUser.CalledWith.User.Vuk)
+	}
+}
+
+func TestAuthenticateIdentRequiresUnlockKeysToCreateUser(t *testing.T) {
+	alice := newTestIdentity()
+	alice.suk, alice.vuk = "", ""
+	store := NewStore().ReturnsUnknownIdentity()
+	store.Func.CreateUser.Returns.User = &ssp.User{Id: "alice", Idk: alice.idk}
+
+	s := anyServer()
+	nut := s.Nut(anyClientIP)
+	w, r := setupAuthenticate(nut, alice.body(sqrl.CmdIdent, nut, false))
+	s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+	got, err := sqrl.ParseServer(w.Body.String())
+	if assert.NoError(t, err) {
+		assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		assert.True(t, got.Is(sqrl.TIFClientFailure))
+	}
+	assert.Empty(t, store.Func.CreateUser.CalledWith.Idk, "Expected no user to be created")
+	assert.Empty(t, store.Func.SaveIdentSuccess.CalledWith.Token, "Expected no token to be issued")
+}
+
+func TestAuthenticateReturnsServerUnlockKey(t *testing.T) {
+	alice := newTestIdentity()
+
+	t.Run("WhenRequestedWithOption", func(t *testing.T) {
+		store := NewStore().ReturnsKnownUser(alice.user())
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdQuery, nut, false, sqrl.OptSUK))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.Equal(t, alice.suk, got.Suk)
+		}
+	})
+
+	t.Run("WhenUserIsDisabled", func(t *testing.T) {
+		user := alice.user()
+		user.Disabled = true
+		store := NewStore().ReturnsKnownUser(user)
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdQuery, nut, false))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.Equal(t, alice.suk, got.Suk)
+		}
+	})
+
+	t.Run("NotWhenNotRequested", func(t *testing.T) {
+		store := NewStore().ReturnsKnownUser(alice.user())
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdQuery, nut, false))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.Empty(t, got.Suk)
+		}
+	})
+
+	t.Run("NotWhenUserIsUnknown", func(t *testing.T) {
+		store := NewStore().ReturnsUnknownIdentity()
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, alice.body(sqrl.CmdQuery, nut, false, sqrl.OptSUK))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.Empty(t, got.Suk)
+		}
+	})
+}
+
+// testIdentity is a SQRL identity that can be used
+// to sign requests in tests.
+type testIdentity struct {
+	idk    sqrl.Identity
+	idkKey ed25519.PrivateKey
+	suk    sqrl.Identity
+	vuk    sqrl.Identity
+	vukKey ed25519.PrivateKey
+}
+
+func newTestIdentity() *testIdentity {
+	idkPub, idkKey, _ := ed25519.GenerateKey(nil)
+	sukPub, _, _ := ed25519.GenerateKey(nil)
+	vukPub, vukKey, _ := ed25519.GenerateKey(nil)
+	return &testIdentity{
+		idk:    sqrl.Identity(sqrl.Base64.EncodeToString(idkPub)),
+		idkKey: idkKey,
+		suk:    sqrl.Identity(sqrl.Base64.EncodeToString(sukPub)),
+		vuk:    sqrl.Identity(sqrl.Base64.EncodeToString(vukPub)),
+		vukKey: vukKey,
+	}
+}
+
+func (id *testIdentity) user() *ssp.User {
+	return &ssp.User{Id: "alice", Idk: id.idk, Suk: id.suk, Vuk: id.vuk}
+}
+
+// body returns a signed form body for the given command. The
+// server unlock and verify unlock keys are always included.
+func (id *testIdentity) body(cmd sqrl.Cmd, nut sqrl.Nut, withUrs bool, opts ...sqrl.Opt) string {
+	server := b64((&sqrl.URL{
+		Host: "example.com",
+		Path: "/cli.sqrl",
+		Nut:  nut,
+		SFN:  "example.com",
+	}).String())
+	return id.bodyWithServer(cmd, server, withUrs, opts...)
+}
+
+func (id *testIdentity) bodyWithServer(cmd sqrl.Cmd, server string, withUrs bool, opts ...sqrl.Opt) string {
+	c := sqrl.ClientMsg{
+		Ver: []string{sqrl.V1},
+		Cmd: cmd,
+		Idk: id.idk,
+		Suk: id.suk,
+		Vuk: id.vuk,
+		Opt: opts,
+	}
+	client, _ := c.Encode()
+	payload := []byte(client + server)
+
+	form := url.Values{}
+	form.Set("client", client)
+	form.Set("server", server)
+	form.Set("ids", sqrl.Base64.EncodeToString(ed25519.Sign(id.idkKey, payload)))
+	if withUrs {
+		form.Set("urs", sqrl.Base64.EncodeToString(ed25519.Sign(id.vukKey, payload)))
+	}
+	return form.Encode()
+}
+
 func b64(in string) string {
 	return sqrl.Base64.EncodeToString([]byte(in))
 }
@@ -142,3 +809,107 @@ func setupAuthenticate(nut sqrl.Nut, body string) (*httptest.ResponseRecorder, *
 	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	return w, r
 }
+
+func TestAuthenticateRekey(t *testing.T) {
+	ctx := context.Background()
+	setup := func() (ssp.Store, *testIdentity, *testIdentity, string) {
+		store := ssp.NewMemoryStore()
+		previous, current := newTestIdentity(), newTestIdentity()
+		user, _ := store.CreateUser(ctx, previous.idk)
+		user.Suk, user.Vuk = previous.suk, previous.vuk
+		//nolint:errcheck
+		store.SaveUser(ctx, user)
+		return store, previous, current, user.Id
+	}
+
+	t.Run("ReplacesPreviousIdentity", func(t *testing.T) {
+		store, previous, current, userID := setup()
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, current.rekeyBody(previous, nut, true))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.False(t, got.Is(sqrl.TIFCommandFailed))
+			assert.True(t, got.Is(sqrl.TIFCurrentIDMatch))
+			assert.False(t, got.Is(sqrl.TIFPreviousIDMatch))
+		}
+
+		user, _ := store.GetUserByIdentity(ctx, current.idk)
+		if assert.NotNil(t, user, "Expected the current identity to be known") {
+			assert.Equal(t, userID, user.Id)
+			assert.Equal(t, current.suk, user.Suk)
+			assert.Equal(t, current.vuk, user.Vuk)
+		}
+		old, _ := store.GetUserByIdentity(ctx, previous.idk)
+		assert.Nil(t, old, "Expected the previous identity to be replaced")
+	})
+
+	t.Run("RequiresUnlockRequestSignature", func(t *testing.T) {
+		store, previous, current, _ := setup()
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, current.rekeyBody(previous, nut, false))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.True(t, got.Is(sqrl.TIFCommandFailed))
+			assert.True(t, got.Is(sqrl.TIFClientFailure))
+		}
+		user, _ := store.GetUserByIdentity(ctx, current.idk)
+		assert.Nil(t, user, "Expected the identity not to be replaced")
+	})
+
+	t.Run("RefusesDisabledUser", func(t *testing.T) {
+		store, previous, current, _ := setup()
+		user, _ := store.GetUserByIdentity(ctx, previous.idk)
+		user.Disabled = true
+		//nolint:errcheck
+		store.SaveUser(ctx, user)
+
+		s := anyServer()
+		nut := s.Nut(anyClientIP)
+		w, r := setupAuthenticate(nut, current.rekeyBody(previous, nut, true))
+		s.ClientHandler(store, anyTokenExchange()).ServeHTTP(w, r)
+
+		got, err := sqrl.ParseServer(w.Body.String())
+		if assert.NoError(t, err) {
+			assert.True(t, got.Is(sqrl.TIFCommandFailed))
+		}
+		replaced, _ := store.GetUserByIdentity(ctx, current.idk)
+		assert.Nil(t, replaced, "Expected the identity not to be replaced")
+	})
+}
+
+// rekeyBody returns a signed ident request that replaces the previous
+// identity, the unlock request is signed with the previous vuk.
+func (id *testIdentity) rekeyBody(previous *testIdentity, nut sqrl.Nut, withUrs bool) string {
+	server := b64((&sqrl.URL{
+		Host: "example.com",
+		Path: "/cli.sqrl",
+		Nut:  nut,
+		SFN:  "example.com",
+	}).String())
+	c := sqrl.ClientMsg{
+		Ver:  []string{sqrl.V1},
+		Cmd:  sqrl.CmdIdent,
+		Idk:  id.idk,
+		Pidk: previous.idk,
+		Suk:  id.suk,
+		Vuk:  id.vuk,
+	}
+	client, _ := c.Encode()
+	payload := []byte(client + server)
+
+	form := url.Values{}
+	form.Set("client", client)
+	form.Set("server", server)
+	form.Set("ids", sqrl.Base64.EncodeToString(ed25519.Sign(id.idkKey, payload)))
+	form.Set("pids", sqrl.Base64.EncodeToString(ed25519.Sign(previous.idkKey, payload)))
+	if withUrs {
+		form.Set("urs", sqrl.Base64.EncodeToString(ed25519.Sign(previous.vukKey, payload)))
+	}
+	return form.Encode()
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	sqrl "github.com/RaniSputnik/sqrl-go"
 	"github.com/RaniSputnik/sqrl-go/ssp"
 )
 
@@ -40,6 +41,101 @@ func TestHandlerNutIsReturned(t *testing.T) {
 	assert.True(t, hasNut, "Missing nut parameter")
 }
 
+func TestHandlerNutIncludesSQRLURL(t *testing.T) {
+	s := httptest.NewServer(anyServer().WithClientEndpoint("/sqrl/cli.sqrl").Handler())
+	res, err := http.Get(s.URL + "/nut.sqrl")
+	fatal(t, assert.NoError(t, err,
+		"Expected no HTTP/connection error"))
+	defer res.Body.Close()
+
+	values, err := parseNutResponse(res)
+	fatal(t, assert.NoError(t, err, "Response error"))
+
+	got, err := sqrl.ParseURL(values.Get("url"))
+	if assert.NoError(t, err, "Expected url parameter to be a SQRL URL") {
+		assert.Equal(t, sqrl.Scheme, got.Scheme)
+		assert.Equal(t, "example.com", got.Host)
+		assert.Equal(t, "/sqrl/cli.sqrl", got.Path)
+		assert.Equal(t, sqrl.Nut(values.Get("nut")), got.Nut)
+	}
+}
+
+func TestHandlerNutIncludesSFN(t *testing.T) {
+	s := httptest.NewServer(anyServer().WithSFN("Example").Handler())
+	res, err := http.Get(s.URL + "/nut.sqrl")
+	fatal(t, assert.NoError(t, err,
+		"Expected no HTTP/connection error"))
+	defer res.Body.Close()
+
+	values, err := parseNutResponse(res)
+	fatal(t, assert.NoError(t, err, "Response error"))
+
+	assert.Equal(t, sqrl.Base64.EncodeToString([]byte("Example")), values.Get("sfn"))
+	got, err := sqrl.ParseURL(values.Get("url"))
+	if assert.NoError(t, err, "Expected url parameter to be a SQRL URL") {
+		assert.Equal(t, "Example", got.SFN)
+	}
+}
+
+func TestHandlerNutIncludesExt(t *testing.T) {
+	s := httptest.NewServer(anyServer().WithClientEndpoint("/alice/cli.sqrl").WithExt(6).Handler())
+	res, err := http.Get(s.URL + "/nut.sqrl")
+	fatal(t, assert.NoError(t, err,
+		"Expected no HTTP/connection error"))
+	defer res.Body.Close()
+
+	values, err := parseNutResponse(res)
+	fatal(t, assert.NoError(t, err, "Response error"))
+
+	got, err := sqrl.ParseURL(values.Get("url"))
+	if assert.NoError(t, err, "Expected url parameter to be a SQRL URL") {
+		assert.Equal(t, 6, got.Ext)
+		assert.Equal(t, "example.com/alice", got.SiteKeyDomain())
+	}
+}
+
+func TestHandlerNutDefaultsSFNToFirstHost(t *testing.T) {
+	s := httptest.NewServer(anyServer().WithHosts("example.com", "www.example.com").Handler())
+	res, err := http.Get(s.URL + "/nut.sqrl")
+	fatal(t, assert.NoError(t, err,
+		"Expected no HTTP/connection error"))
+	defer res.Body.Close()
+
+	values, err := parseNutResponse(res)
+	fatal(t, assert.NoError(t, err, "Response error"))
+
+	assert.Equal(t, sqrl.Base64.EncodeToString([]byte("example.com")), values.Get("sfn"))
+}
+
+func TestHandlerNutUsesRequestHostOnlyIfConfigured(t *testing.T) {
+	s := anyServer().WithHosts("example.com", "www.example.com")
+
+	for requestHost, want := range map[string]string{
+		"www.example.com":  "www.example.com",
+		"evil.example.com": "example.com",
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://"+requestHost+"/nut.sqrl", nil)
+		s.NutHandler(w, r)
+
+		values, err := url.ParseQuery(w.Body.String())
+		fatal(t, assert.NoError(t, err, "Response error"))
+		got, err := sqrl.ParseURL(values.Get("url"))
+		if assert.NoError(t, err, "Expected url parameter to be a SQRL URL") {
+			assert.Equal(t, want, got.Host, "Unexpected host for request to '%s'", requestHost)
+		}
+	}
+}
+
+func TestHandlerNutRequiresConfiguredHosts(t *testing.T) {
+	s := ssp.Configure(make([]byte, 16), "http://example.com/auth/callback")
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/nut.sqrl", nil)
+	s.NutHandler(w, r)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
 func parseNutResponse(res *http.Response) (url.Values, error) {
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -120,8 +216,13 @@ func fatal(t *testing.T, ok bool) {
 	}
 }
 
+// anyClientIP is the IP address httptest
+// uses for the remote address of requests.
+const anyClientIP = "192.0.2.1"
+
 func anyServer() *ssp.Server {
-	return ssp.Configure(make([]byte, 16), "http://example.com/auth/callback")
+	return ssp.Configure(make([]byte, 16), "http://example.com/auth/callback").
+		WithHosts("example.com")
 }
 
 func anyTokenExchange() ssp.TokenExchange {
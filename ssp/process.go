@@ -0,0 +1,349 @@
+package ssp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	sqrl "github.com/RaniSputnik/sqrl-go"
+)
+
+var (
+	// ErrUnknownIdentity the command requires an identity
+	// that is not known to the server.
+	ErrUnknownIdentity = errors.New("identity is not known")
+	// ErrSQRLDisabled the user has disabled SQRL sign in
+	// and must re-enable it before they can use it again.
+	ErrSQRLDisabled = errors.New("sqrl is disabled for user")
+	// ErrMissingUnlockKeys the client did not provide the server
+	// and verify unlock keys required to create or rekey an identity.
+	ErrMissingUnlockKeys = errors.New("missing unlock keys")
+)
+
+// Outcome describes what happened when a request from a SQRL
+// client was processed. It is intended for transports and
+// logging, the client should only ever be sent the response.
+type Outcome struct {
+	// Cmd is the command requested by the client,
+	// empty if the request could not be parsed.
+	Cmd sqrl.Cmd
+	// UserID is the id of the user the identity belongs
+	// to, empty if the identity is not known.
+	UserID string
+	// Token is issued when an ident command succeeds and
+	// can be exchanged for the id of the user.
+	Token Token
+	// Err is the reason the request failed, nil if it succeeded.
+	Err error
+}
+
+// Process handles a request from a SQRL client, performing the query,
+// ident, disable, enable and remove commands against the configured
+// store and token exchange. The response that must be returned to the
+// client is always provided, even if processing failed.
+//
+// Process does not depend on net/http so it can be used with any transport.
+// The hosts that SQRL URLs are issued for must be configured with WithHosts.
+func (server *Server) Process(ctx context.Context, req *sqrl.Request) (*sqrl.ServerMsg, Outcome) {
+	return server.process(ctx, server.store, server.exchange, req)
+}
+
+func (server *Server) process(ctx context.Context, store Store, tokens TokenGenerator, req *sqrl.Request) (*sqrl.ServerMsg, Outcome) {
+	response := genNextResponse(server, req.ClientIP)
+	var outcome Outcome
+	outcome.Err = server.handle(ctx, store, tokens, req, response, &outcome)
+	if outcome.Err != nil {
+		// The client must never mistake a failed request for
+		// a successful one, errors that did not set any flags
+		// are treated as a server error.
+		if !response.Is(sqrl.TIFCommandFailed) {
+			serverError(response)
+		}
+		server.logger.Printf("Failed to process request: %v\n", outcome.Err)
+	}
+	server.saveResponse(ctx, store, response)
+	return response, outcome
+}
+
+func (server *Server) handle(ctx context.Context, store Store, tokens TokenGenerator, req *sqrl.Request, response *sqrl.ServerMsg, outcome *Outcome) error {
+	// Without the hosts we can not verify which
+	// site the client believes it is signing in to.
+	site := server.site()
+	if len(site.Hosts) == 0 {
+		response.Set(sqrl.TIFCommandFailed)
+		return ErrNoHosts
+	}
+
+	// Refuse stale or forged nuts before touching the store.
+	// An expired nut is a transient error, the client can
+	// retry immediately with the fresh nut in our response.
+	if _, err := server.nutter.Validate(req.Nut); err == sqrl.ErrNutExpired {
+		response.Set(sqrl.TIFCommandFailed).Set(sqrl.TIFTransientError)
+		return fmt.Errorf("nut '%s': %w", req.Nut, err)
+	} else if err != nil {
+		clientFailure(response)
+		return fmt.Errorf("nut '%s': %w", req.Nut, err)
+	}
+
+	// Reject malformed requests and bad signatures
+	// before any state is retrieved from the store.
+	client, err := sqrl.ParseRequest(req, response)
+	if err != nil {
+		return err
+	}
+	outcome.Cmd = client.Cmd
+	if _, err := sqrl.VerifySignatures(req, client, response); err != nil {
+		return err
+	}
+
+	firstTransaction, err := store.GetFirstTransaction(ctx, req.Nut)
+	if err != nil {
+		serverError(response)
+		return fmt.Errorf("failed to retrieve first transaction: %w", err)
+	}
+
+	lastResponse, err := store.GetResponse(ctx, req.Nut)
+	if err != nil {
+		serverError(response)
+		return fmt.Errorf("failed to retrieve last response: %w", err)
+	}
+
+	if _, err := sqrl.VerifyServer(req, site, firstTransaction, lastResponse, response); err != nil {
+		return err
+	}
+	if err := sqrl.VerifySession(req, client, firstTransaction, response); err != nil {
+		return err
+	}
+
+	// Refuse clients that do not support any
+	// of the protocol versions that we do.
+	if err := negotiateVersion(client); err != nil {
+		clientFailure(response)
+		return fmt.Errorf("unsupported client versions '%s': %w", strings.Join(client.Ver, ","), err)
+	}
+
+	// Each nut may only be answered once, this prevents the
+	// request from being replayed or processed concurrently.
+	// A genuine client never reuses a nut, so a replay is
+	// not something the client should retry.
+	if err := store.ConsumeNut(ctx, req.Nut); err == ErrNutAlreadyUsed {
+		clientFailure(response)
+		return fmt.Errorf("nut '%s': %w", req.Nut, err)
+	} else if err != nil {
+		serverError(response)
+		return fmt.Errorf("failed to consume nut: %w", err)
+	}
+
+	if err := store.SaveTransaction(ctx, &sqrl.Transaction{
+		Request: req,
+		Next:    response.Nut,
+	}); err != nil {
+		serverError(response)
+		return fmt.Errorf("failed to save transaction: %w", err)
+	}
+
+	currentUser, err := store.GetUserByIdentity(ctx, client.Idk)
+	if err != nil {
+		serverError(response)
+		return fmt.Errorf("failed to determine if identity is known: %w", err)
+	} else if currentUser != nil {
+		outcome.UserID = currentUser.Id
+		response.Set(sqrl.TIFCurrentIDMatch)
+		if currentUser.Disabled {
+			response.Set(sqrl.TIFSQRLDisabled)
+		}
+	}
+
+	// The previous identity has been verified by sqrl.VerifySignatures
+	// so we can safely check whether it is known to us.
+	var previousUser *User
+	if currentUser == nil && client.Pidk != "" {
+		previousUser, err = store.GetUserByIdentity(ctx, client.Pidk)
+		if err != nil {
+			serverError(response)
+			return fmt.Errorf("failed to determine if previous identity is known: %w", err)
+		} else if previousUser != nil {
+			outcome.UserID = previousUser.Id
+			response.Set(sqrl.TIFPreviousIDMatch)
+		}
+	}
+
+	// The client needs the server unlock key to build an unlock
+	// request signature, so return it whenever it is asked for
+	// or the user will need it to re-enable their account.
+	matchedUser := currentUser
+	if matchedUser == nil {
+		matchedUser = previousUser
+	}
+	if matchedUser != nil && (client.HasOpt(sqrl.OptSUK) || matchedUser.Disabled) {
+		response.Suk = matchedUser.Suk
+	}
+
+	switch client.Cmd {
+	case sqrl.CmdIdent:
+		if currentUser != nil && currentUser.Disabled {
+			response.Set(sqrl.TIFCommandFailed)
+			return fmt.Errorf("refusing ident for user '%s': %w", currentUser.Id, ErrSQRLDisabled)
+		}
+
+		// The user has rekeyed their identity, the previous identity
+		// is replaced once the client proves it holds the previous
+		// identity unlock key by signing with the unlock request key.
+		if currentUser == nil && previousUser != nil {
+			if previousUser.Disabled {
+				response.Set(sqrl.TIFCommandFailed)
+				return fmt.Errorf("refusing rekey for user '%s': %w", previousUser.Id, ErrSQRLDisabled)
+			}
+			if err := sqrl.VerifyUnlock(req, previousUser.Vuk, response); err != nil {
+				return err
+			}
+			if client.Suk == "" || client.Vuk == "" {
+				clientFailure(response)
+				return fmt.Errorf("refusing rekey for user '%s': %w", previousUser.Id, ErrMissingUnlockKeys)
+			}
+
+			previousUser.Idk = client.Idk
+			previousUser.Suk = client.Suk
+			previousUser.Vuk = client.Vuk
+			if err := store.SaveUser(ctx, previousUser); err != nil {
+				serverError(response)
+				return fmt.Errorf("failed to rekey user: %w", err)
+			}
+			currentUser = previousUser
+			response.Unset(sqrl.TIFPreviousIDMatch).Set(sqrl.TIFCurrentIDMatch)
+			if response.Suk != "" {
+				response.Suk = currentUser.Suk
+			}
+		}
+
+		// Create user if they do not already exist, without the
+		// unlock keys the user could never disable, enable,
+		// remove or rekey their identity so they are required.
+		if currentUser == nil {
+			if client.Suk == "" || client.Vuk == "" {
+				clientFailure(response)
+				return fmt.Errorf("refusing to create user: %w", ErrMissingUnlockKeys)
+			}
+			currentUser, err = store.CreateUser(ctx, client.Idk)
+			if err != nil {
+				serverError(response)
+				return fmt.Errorf("failed to create user: %w", err)
+			}
+			outcome.UserID = currentUser.Id
+			currentUser.Suk = client.Suk
+			currentUser.Vuk = client.Vuk
+			if err := store.SaveUser(ctx, currentUser); err != nil {
+				serverError(response)
+				return fmt.Errorf("failed to save user unlock keys: %w", err)
+			}
+		}
+
+		// Generate a new token that can be exchanged for user credentials
+		// TODO: It would be great if we could guarantee the size of tokens
+		// for DB backends that want to specify the column size for the token
+		token := tokens.Token(currentUser.Id)
+		// Record that this transaction was a success, store the token
+		sessionID := req.Nut
+		if firstTransaction != nil {
+			sessionID = firstTransaction.Nut
+		}
+		if err := store.SaveIdentSuccess(ctx, sessionID, token); err != nil {
+			serverError(response)
+			return fmt.Errorf("failed to save ident success: %w", err)
+		}
+		outcome.Token = token
+
+		if client.HasOpt(sqrl.OptCPS) {
+			response.URL = getTokenRedirectURL(server, token)
+		}
+	case sqrl.CmdQuery:
+		// TODO: Anything need to be done here?
+
+	case sqrl.CmdDisable:
+		if currentUser == nil {
+			response.Set(sqrl.TIFCommandFailed)
+			return fmt.Errorf("refusing disable: %w", ErrUnknownIdentity)
+		}
+
+		currentUser.Disabled = true
+		if err := store.SaveUser(ctx, currentUser); err != nil {
+			serverError(response)
+			return fmt.Errorf("failed to disable user: %w", err)
+		}
+		response.Set(sqrl.TIFSQRLDisabled)
+
+	case sqrl.CmdEnable:
+		if currentUser == nil {
+			response.Set(sqrl.TIFCommandFailed)
+			return fmt.Errorf("refusing enable: %w", ErrUnknownIdentity)
+		}
+		if err := sqrl.VerifyUnlock(req, currentUser.Vuk, response); err != nil {
+			return err
+		}
+
+		currentUser.Disabled = false
+		if err := store.SaveUser(ctx, currentUser); err != nil {
+			serverError(response)
+			return fmt.Errorf("failed to enable user: %w", err)
+		}
+		response.Unset(sqrl.TIFSQRLDisabled)
+
+	case sqrl.CmdRemove:
+		if currentUser == nil {
+			response.Set(sqrl.TIFCommandFailed)
+			return fmt.Errorf("refusing remove: %w", ErrUnknownIdentity)
+		}
+		if err := sqrl.VerifyUnlock(req, currentUser.Vuk, response); err != nil {
+			return err
+		}
+
+		if err := store.DeleteUser(ctx, currentUser.Id); err != nil {
+			serverError(response)
+			return fmt.Errorf("failed to remove user: %w", err)
+		}
+		response.Unset(sqrl.TIFCurrentIDMatch).Unset(sqrl.TIFSQRLDisabled)
+
+	default:
+		// In all other cases, not supported
+		response.Set(sqrl.TIFFunctionNotSupported)
+	}
+	return nil
+}
+
+func negotiateVersion(client *sqrl.ClientMsg) error {
+	versions, err := client.Versions()
+	if err != nil {
+		return err
+	}
+	_, err = versions.Negotiate(sqrl.SupportedVersions)
+	return err
+}
+
+func genNextResponse(server *Server, clientIP string) *sqrl.ServerMsg {
+	nextNut := server.Nut(clientIP)
+	return &sqrl.ServerMsg{
+		Ver: sqrl.SupportedVersions.Strings(),
+		Nut: nextNut,
+		Qry: (&sqrl.URL{Path: server.clientEndpoint, Nut: nextNut}).RequestURI(),
+	}
+}
+
+func encodeResponse(response *sqrl.ServerMsg) string {
+	encoded, err := response.Encode()
+	if err != nil {
+		panic(err)
+	}
+	return encoded
+}
+
+// saveResponse saves the exact response that is sent to the client
+// so that the server param of the next request can be verified.
+func (server *Server) saveResponse(ctx context.Context, store TransactionStore, response *sqrl.ServerMsg) {
+	if err := store.SaveResponse(ctx, response.Nut, encodeResponse(response)); err != nil {
+		server.logger.Printf("Failed to save response: %v\n", err)
+		// The client will be unable to continue with the
+		// nut we issued, let them know to try again.
+		serverError(response)
+	}
+}
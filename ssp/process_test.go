@@ -0,0 +1,159 @@
+package ssp_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	sqrl "github.com/RaniSputnik/sqrl-go"
+	"github.com/RaniSputnik/sqrl-go/ssp"
+)
+
+func TestProcess(t *testing.T) {
+	alice := newTestIdentity()
+
+	processServer := func(store ssp.Store) *ssp.Server {
+		return anyServer().
+			WithStore(store).
+			WithTokenExchange(anyTokenExchange()).
+			WithHosts("example.com").
+			WithSFN("example.com")
+	}
+
+	t.Run("IdentIssuesToken", func(t *testing.T) {
+		store := NewStore().ReturnsKnownUser(alice.user())
+		s := processServer(store)
+		nut := s.Nut(anyClientIP)
+
+		response, outcome := s.Process(context.Background(), alice.request(sqrl.CmdIdent, nut))
+		assert.NoError(t, outcome.Err)
+		assert.Equal(t, sqrl.CmdIdent, outcome.Cmd)
+		assert.Equal(t, "alice", outcome.UserID)
+		assert.NotEmpty(t, outcome.Token)
+		assert.Equal(t, outcome.Token, store.Func.SaveIdentSuccess.CalledWith.Token)
+		assert.True(t, response.Is(sqrl.TIFCurrentIDMatch))
+		assert.False(t, response.Is(sqrl.TIFCommandFailed))
+	})
+
+	t.Run("SavesTheResponse", func(t *testing.T) {
+		store := NewStore().ReturnsUnknownIdentity()
+		s := processServer(store)
+		nut := s.Nut(anyClientIP)
+
+		response, _ := s.Process(context.Background(), alice.request(sqrl.CmdQuery, nut))
+		encoded, err := response.Encode()
+		if assert.NoError(t, err) {
+			assert.Equal(t, response.Nut, store.Func.SaveResponse.CalledWith.Nut)
+			assert.Equal(t, encoded, store.Func.SaveResponse.CalledWith.Response)
+		}
+	})
+
+	t.Run("ReportsUnknownIdentity", func(t *testing.T) {
+		store := NewStore().ReturnsUnknownIdentity()
+		s := processServer(store)
+		nut := s.Nut(anyClientIP)
+
+		response, outcome := s.Process(context.Background(), alice.request(sqrl.CmdDisable, nut))
+		assert.True(t, errors.Is(outcome.Err, ssp.ErrUnknownIdentity), "Expected ErrUnknownIdentity, got: %v", outcome.Err)
+		assert.Equal(t, sqrl.CmdDisable, outcome.Cmd)
+		assert.Empty(t, outcome.UserID)
+		assert.True(t, response.Is(sqrl.TIFCommandFailed))
+	})
+
+	t.Run("ReportsInvalidSignature", func(t *testing.T) {
+		store := NewStore()
+		s := processServer(store)
+		nut := s.Nut(anyClientIP)
+		req := alice.request(sqrl.CmdQuery, nut)
+		req.Ids = "invalid"
+
+		response, outcome := s.Process(context.Background(), req)
+		assert.Equal(t, sqrl.ErrInvalidIDSig, outcome.Err)
+		assert.True(t, response.Is(sqrl.TIFClientFailure))
+	})
+
+	t.Run("DefaultsSFNToFirstHost", func(t *testing.T) {
+		store := NewStore().ReturnsUnknownIdentity()
+		s := ssp.Configure(make([]byte, 16), "http://example.com/auth/callback").
+			WithStore(store).
+			WithHosts("example.com", "www.example.com")
+		nut := s.Nut(anyClientIP)
+
+		response, outcome := s.Process(context.Background(), alice.request(sqrl.CmdQuery, nut))
+		assert.NoError(t, outcome.Err)
+		assert.False(t, response.Is(sqrl.TIFCommandFailed))
+	})
+
+	t.Run("RequiresConfiguredHosts", func(t *testing.T) {
+		store := NewStore().ReturnsUnknownIdentity()
+		s := ssp.Configure(make([]byte, 16), "http://example.com/auth/callback").
+			WithStore(store).
+			WithSFN("example.com")
+		nut := s.Nut(anyClientIP)
+
+		response, outcome := s.Process(context.Background(), alice.request(sqrl.CmdQuery, nut))
+		assert.Equal(t, ssp.ErrNoHosts, outcome.Err)
+		assert.True(t, response.Is(sqrl.TIFCommandFailed))
+	})
+
+	t.Run("FailsWhenClientIPIsMissing", func(t *testing.T) {
+		store := NewStore().ReturnsUnknownIdentity()
+		s := processServer(store)
+		nut := s.Nut(anyClientIP)
+		req := alice.request(sqrl.CmdQuery, nut)
+		req.ClientIP = ""
+
+		response, outcome := s.Process(context.Background(), req)
+		assert.Error(t, outcome.Err)
+		assert.True(t, response.Is(sqrl.TIFCommandFailed))
+		assert.True(t, response.Is(sqrl.TIFTransientError))
+	})
+
+	t.Run("FailsWhenClientIPChanges", func(t *testing.T) {
+		s := processServer(ssp.NewMemoryStore())
+		nut := s.Nut(anyClientIP)
+
+		first, outcome := s.Process(context.Background(), alice.request(sqrl.CmdQuery, nut))
+		if !assert.NoError(t, outcome.Err) {
+			return
+		}
+		req := alice.followUp(sqrl.CmdQuery, first)
+		req.ClientIP = "198.51.100.1"
+
+		response, outcome := s.Process(context.Background(), req)
+		assert.Equal(t, sqrl.ErrIPMismatch, outcome.Err)
+		assert.True(t, response.Is(sqrl.TIFCommandFailed))
+		assert.True(t, response.Is(sqrl.TIFClientFailure))
+		assert.False(t, response.Is(sqrl.TIFIPMatch))
+	})
+}
+
+// request returns a signed request for the given command,
+// as it would be received over any transport.
+func (id *testIdentity) request(cmd sqrl.Cmd, nut sqrl.Nut) *sqrl.Request {
+	form, _ := url.ParseQuery(id.body(cmd, nut, false))
+	return &sqrl.Request{
+		Nut:      nut,
+		Client:   form.Get("client"),
+		Server:   form.Get("server"),
+		Ids:      sqrl.Signature(form.Get("ids")),
+		ClientIP: anyClientIP,
+	}
+}
+
+// followUp returns a signed request for the given command that
+// continues the session in which the previous response was sent.
+func (id *testIdentity) followUp(cmd sqrl.Cmd, previous *sqrl.ServerMsg) *sqrl.Request {
+	server, _ := previous.Encode()
+	form, _ := url.ParseQuery(id.bodyWithServer(cmd, server, false))
+	return &sqrl.Request{
+		Nut:      previous.Nut,
+		Client:   form.Get("client"),
+		Server:   form.Get("server"),
+		Ids:      sqrl.Signature(form.Get("ids")),
+		ClientIP: anyClientIP,
+	}
+}
@@ -1,12 +1,23 @@
 package ssp
 
 import (
+	"errors"
+	"net/http"
+	"strings"
 	"time"
 
 	sqrl "github.com/RaniSputnik/sqrl-go"
 	"github.com/tomasen/realip"
 )
 
+// DefaultNutExpiry is how long a nut issued by the
+// server remains valid for use by a SQRL client.
+const DefaultNutExpiry = 5 * time.Minute
+
+// ErrNoHosts the server has not been configured with the
+// hosts that it issues SQRL links for, see WithHosts.
+var ErrNoHosts = errors.New("no hosts configured")
+
 type Logger interface {
 	Printf(format string, v ...interface{})
 }
@@ -15,6 +26,8 @@ type donothingLogger struct{}
 
 func (_ donothingLogger) Printf(format string, v ...interface{}) {}
 
+// Server is a SQRL service provider, see Configure. It refuses
+// to issue SQRL links or answer clients until WithHosts is set.
 type Server struct {
 	key []byte
 
@@ -24,14 +37,21 @@ type Server struct {
 	validator      ServerToServerAuthValidationFunc
 	redirectURL    string
 	clientEndpoint string
+	hosts          []string
+	ext            int
+	sfn            string
 
 	nutter sqrl.Nutter
 }
 
+// Configure creates a server that signs tokens with the given key and
+// redirects users to the given URL once they have signed in. The hosts
+// that SQRL URLs are issued for must also be set with WithHosts, until
+// they are every SQRL link and client request is refused with ErrNoHosts.
 func Configure(key []byte, redirectURL string) *Server {
 	store := NewMemoryStore()
 	exchange := DefaultExchange(key, time.Minute)
-	nutter := sqrl.NewNutter()
+	nutter := sqrl.NewNutter(DefaultNutExpiry)
 
 	return &Server{
 		key: key,
@@ -81,8 +101,88 @@ func (s *Server) WithClientEndpoint(url string) *Server {
 	return s
 }
 
-func (s *Server) Nut() sqrl.Nut {
-	return s.nutter.Next()
+// WithHosts sets the domains (including the port if it is not
+// the default) that SQRL URLs may be issued for. The URL signed
+// by a client is rejected if it was issued for any other domain.
+//
+// At least one host is required, the Host of a client request
+// is never trusted. SQRL links are issued for the Host of the
+// request only if it is one of the hosts, otherwise the first.
+func (s *Server) WithHosts(hosts ...string) *Server {
+	s.hosts = hosts
+	return s
+}
+
+// WithExt sets the number of characters of the client endpoint
+// path that are included in the site key, the x= parameter of the
+// SQRL URLs the server issues. This allows sites that share a
+// domain to be given a different identity by SQRL clients.
+//
+// Defaults to 0 if not set, only the domain is used.
+func (s *Server) WithExt(ext int) *Server {
+	s.ext = ext
+	return s
+}
+
+// WithSFN sets the server friendly name, the name of the
+// site that SQRL clients display to the user when signing in.
+// It is included in every SQRL link the server produces.
+//
+// Defaults to the first of the hosts if not set.
+func (s *Server) WithSFN(name string) *Server {
+	s.sfn = name
+	return s
+}
+
+// WithNutter sets the nut generator used to issue and validate
+// the nuts given to SQRL clients. Defaults to sqrl.NewNutter if
+// not set, sqrl.NewAESNutter can be used for stateless nuts and
+// sqrl.NewKeyringNutter when nut keys need to be shared or rotated.
+func (s *Server) WithNutter(nutter sqrl.Nutter) *Server {
+	s.nutter = nutter
+	return s
+}
+
+// Nut returns a new nut for a request
+// made from the given client IP address.
+func (s *Server) Nut(clientIP string) sqrl.Nut {
+	return s.nutter.Next(clientIP)
+}
+
+// site returns the description of the SQRL URLs this server issues.
+// Links are generated from the same configuration, so a server
+// always accepts the links that it issues.
+func (s *Server) site() sqrl.Site {
+	return sqrl.Site{
+		Hosts: s.hosts,
+		Path:  s.clientEndpoint,
+		Ext:   s.ext,
+		SFN:   s.friendlyName(),
+	}
+}
+
+// friendlyName returns the server friendly name
+// that is included in the links the server issues.
+func (s *Server) friendlyName() string {
+	if s.sfn == "" && len(s.hosts) > 0 {
+		return s.hosts[0]
+	}
+	return s.sfn
+}
+
+// linkHost returns the host to issue a SQRL link for in response
+// to the given request. The Host of the request is controlled by
+// the client, so it is only used if it is one of the hosts.
+func (s *Server) linkHost(r *http.Request) (string, error) {
+	if len(s.hosts) == 0 {
+		return "", ErrNoHosts
+	}
+	for _, host := range s.hosts {
+		if strings.EqualFold(host, r.Host) {
+			return host, nil
+		}
+	}
+	return s.hosts[0], nil
 }
 
 // ClientIP is the function that is used to extract the client ip string
@@ -2,16 +2,37 @@ package ssp
 
 import (
 	"context"
+	"errors"
 
 	sqrl "github.com/RaniSputnik/sqrl-go"
 )
 
+// ErrNutAlreadyUsed is returned by ConsumeNut when
+// the given nut has already been consumed.
+var ErrNutAlreadyUsed = errors.New("nut already used")
+
 type TransactionStore interface {
 	// GetFirstTransaction returns the transaction that started an exchange between
 	// a SQRL client and SSP server. If no error or transaction is returned then
 	// the current transaction is the first transaction in the exchange.
 	GetFirstTransaction(ctx context.Context, nut sqrl.Nut) (*sqrl.Transaction, error)
 
+	// ConsumeNut marks the given nut as used. It must be atomic, if
+	// the nut has already been consumed ErrNutAlreadyUsed is returned
+	// and only one of any concurrent calls for a nut may succeed.
+	// This prevents a client request from being replayed. Nuts may
+	// be forgotten once they have expired, as they will be refused.
+	ConsumeNut(ctx context.Context, nut sqrl.Nut) error
+
+	// SaveResponse stores the exact encoded server message that was returned
+	// to the client alongside the given (newly issued) nut. The client must
+	// echo this message back as the server parameter of its next request.
+	SaveResponse(ctx context.Context, nut sqrl.Nut, response string) error
+
+	// GetResponse returns the server message that was saved for the given
+	// nut. An empty string will be returned if no response has been saved.
+	GetResponse(ctx context.Context, nut sqrl.Nut) (response string, err error)
+
 	// SaveTransaction stores a verified transaction in the DB.
 	SaveTransaction(ctx context.Context, t *sqrl.Transaction) error
 
@@ -36,12 +57,31 @@ type UserStore interface {
 	// see: https://github.com/RaniSputnik/sqrl-go/issues/25
 	GetUserByIdentity(ctx context.Context, idk sqrl.Identity) (*User, error)
 
+	// SaveUser updates a user that was previously created with CreateUser.
+	// The Idk of the user is replaced when the user rekeys their identity.
+	SaveUser(ctx context.Context, user *User) error
+
+	// DeleteUser removes all trace of the user with the given id.
+	// No error should be returned if the user does not exist.
+	DeleteUser(ctx context.Context, id string) error
+
 	// TODO: Get by previous identities
 }
 
 type User struct {
 	Id  string
 	Idk sqrl.Identity
+	// Suk is the server unlock key provided by the client when
+	// the user was created. It is returned to the client on request
+	// so that the client can build an unlock request signature.
+	Suk sqrl.Identity
+	// Vuk is the verify unlock key provided by the client when
+	// the user was created. It is used to check the unlock request
+	// signature of enable and remove requests.
+	Vuk sqrl.Identity
+	// Disabled is set when the client has requested that SQRL
+	// authentication be disabled for this user.
+	Disabled bool
 	// TODO: Do we need to store previous identity keys?
 }
 
@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"fmt"
 	"sync"
+	"time"
 
 	sqrl "github.com/RaniSputnik/sqrl-go"
 )
@@ -14,6 +15,13 @@ type inmemoryStore struct {
 	transactions map[sqrl.Nut]*sqrl.Transaction
 	// Transaction Nut -> First Transaction Nut
 	firstTransactions map[sqrl.Nut]sqrl.Nut
+	// Nuts that have been consumed, in the order they were consumed
+	usedNuts     map[sqrl.Nut]struct{}
+	usedNutOrder []timedNut
+	nutExpiry    time.Duration
+	// Issued Nut -> Encoded server response
+	responses     map[sqrl.Nut]string
+	responseOrder []timedNut
 	// First Transaction Nut -> Auth Token
 	tokens map[sqrl.Nut]Token
 	// List of users
@@ -22,10 +30,29 @@ type inmemoryStore struct {
 	sync.Mutex
 }
 
+// timedNut records when a nut was consumed or responded with.
+type timedNut struct {
+	nut sqrl.Nut
+	at  time.Time
+}
+
+// NewMemoryStore creates a Store that holds everything in memory.
+//
+// Consumed nuts and the responses sent with each nut are forgotten
+// once DefaultNutExpiry has passed, as the nutter will refuse those
+// nuts as expired. Servers configured with a nutter that has a
+// longer expiry should not use the memory store.
 func NewMemoryStore() Store {
+	return newMemoryStore(DefaultNutExpiry)
+}
+
+func newMemoryStore(nutExpiry time.Duration) *inmemoryStore {
 	return &inmemoryStore{
 		transactions:      map[sqrl.Nut]*sqrl.Transaction{},
 		firstTransactions: map[sqrl.Nut]sqrl.Nut{},
+		usedNuts:          map[sqrl.Nut]struct{}{},
+		nutExpiry:         nutExpiry,
+		responses:         map[sqrl.Nut]string{},
 		tokens:            map[sqrl.Nut]Token{},
 	}
 }
@@ -40,6 +67,72 @@ func (s *inmemoryStore) GetFirstTransaction(ctx context.Context, nut sqrl.Nut) (
 	return s.transactions[firstTransactionId], nil
 }
 
+func (s *inmemoryStore) ConsumeNut(ctx context.Context, nut sqrl.Nut) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	s.forgetExpiredNuts(now)
+	if _, used := s.usedNuts[nut]; used {
+		return ErrNutAlreadyUsed
+	}
+	s.usedNuts[nut] = struct{}{}
+	s.usedNutOrder = append(s.usedNutOrder, timedNut{nut: nut, at: now})
+	return nil
+}
+
+// forgetExpiredNuts removes nuts that were consumed longer
+// ago than the nut expiry, by which time they can no longer
+// be replayed. Nuts are held in the order they were consumed
+// so we only need to check the oldest nuts.
+func (s *inmemoryStore) forgetExpiredNuts(now time.Time) {
+	expired := s.countExpired(s.usedNutOrder, now)
+	for _, used := range s.usedNutOrder[:expired] {
+		delete(s.usedNuts, used.nut)
+	}
+	s.usedNutOrder = s.usedNutOrder[expired:]
+}
+
+// forgetExpiredResponses removes responses that were sent
+// with a nut longer ago than the nut expiry, by which time
+// no client can follow up on them.
+func (s *inmemoryStore) forgetExpiredResponses(now time.Time) {
+	expired := s.countExpired(s.responseOrder, now)
+	for _, saved := range s.responseOrder[:expired] {
+		delete(s.responses, saved.nut)
+	}
+	s.responseOrder = s.responseOrder[expired:]
+}
+
+// countExpired returns how many of the oldest nuts have expired.
+func (s *inmemoryStore) countExpired(order []timedNut, now time.Time) int {
+	expired := 0
+	for _, timed := range order {
+		if now.Sub(timed.at) <= s.nutExpiry {
+			break
+		}
+		expired++
+	}
+	return expired
+}
+
+func (s *inmemoryStore) SaveResponse(ctx context.Context, nut sqrl.Nut, response string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	s.forgetExpiredResponses(now)
+	s.responses[nut] = response
+	s.responseOrder = append(s.responseOrder, timedNut{nut: nut, at: now})
+	return nil
+}
+
+func (s *inmemoryStore) GetResponse(ctx context.Context, nut sqrl.Nut) (response string, err error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.responses[nut], nil
+}
+
 func (s *inmemoryStore) SaveTransaction(ctx context.Context, t *sqrl.Transaction) error {
 	firstTransaction, err := s.GetFirstTransaction(ctx, t.Nut)
 	if err != nil {
@@ -79,7 +172,8 @@ func (s *inmemoryStore) CreateUser(ctx context.Context, idk sqrl.Identity) (*Use
 		Idk: idk,
 	}
 	s.users = append(s.users, newUser)
-	return newUser, nil
+	created := *newUser
+	return &created, nil
 }
 
 func (s *inmemoryStore) GetUserByIdentity(ctx context.Context, idk sqrl.Identity) (*User, error) {
@@ -88,13 +182,45 @@ func (s *inmemoryStore) GetUserByIdentity(ctx context.Context, idk sqrl.Identity
 
 	for _, user := range s.users {
 		if user.Idk == idk {
-			return user, nil
+			// Return a copy so that changes are only
+			// persisted when SaveUser is called.
+			found := *user
+			return &found, nil
 		}
 	}
 
 	return nil, nil
 }
 
+func (s *inmemoryStore) SaveUser(ctx context.Context, user *User) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, existing := range s.users {
+		if existing.Id == user.Id {
+			updated := *user
+			s.users[i] = &updated
+			return nil
+		}
+	}
+
+	return fmt.Errorf("user '%s' does not exist", user.Id)
+}
+
+func (s *inmemoryStore) DeleteUser(ctx context.Context, id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, user := range s.users {
+		if user.Id == id {
+			s.users = append(s.users[:i], s.users[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
 func uuid() string {
 	b := make([]byte, 16)
 	_, err := rand.Read(b)
@@ -3,6 +3,7 @@ package ssp_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	sqrl "github.com/RaniSputnik/sqrl-go"
 	"github.com/RaniSputnik/sqrl-go/ssp"
@@ -86,6 +87,96 @@ func TestMemoryStoreGetFirstTransaction(t *testing.T) {
 	})
 }
 
+func TestMemoryStoreConsumeNut(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("SucceedsTheFirstTime", func(t *testing.T) {
+		s := ssp.NewMemoryStore()
+		err := s.ConsumeNut(ctx, sqrl.Nut("somenut"))
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsNutAlreadyUsedTheSecondTime", func(t *testing.T) {
+		s := ssp.NewMemoryStore()
+		_ = s.ConsumeNut(ctx, sqrl.Nut("somenut"))
+		err := s.ConsumeNut(ctx, sqrl.Nut("somenut"))
+		assert.Equal(t, ssp.ErrNutAlreadyUsed, err)
+	})
+
+	t.Run("DoesNotAffectOtherNuts", func(t *testing.T) {
+		s := ssp.NewMemoryStore()
+		_ = s.ConsumeNut(ctx, sqrl.Nut("somenut"))
+		err := s.ConsumeNut(ctx, sqrl.Nut("someothernut"))
+		assert.Nil(t, err)
+	})
+
+	t.Run("ForgetsNutsOnceTheyHaveExpired", func(t *testing.T) {
+		veryShortExpiry := time.Millisecond
+		s := ssp.NewMemoryStoreWithNutExpiry(veryShortExpiry)
+		_ = s.ConsumeNut(ctx, sqrl.Nut("somenut"))
+
+		time.Sleep(veryShortExpiry * 3)
+
+		err := s.ConsumeNut(ctx, sqrl.Nut("somenut"))
+		assert.Nil(t, err)
+	})
+
+	t.Run("OnlyOneConcurrentCallSucceeds", func(t *testing.T) {
+		s := ssp.NewMemoryStore()
+		const attempts = 50
+
+		results := make(chan error, attempts)
+		for i := 0; i < attempts; i++ {
+			go func() {
+				results <- s.ConsumeNut(ctx, sqrl.Nut("somenut"))
+			}()
+		}
+
+		succeeded := 0
+		for i := 0; i < attempts; i++ {
+			if err := <-results; err == nil {
+				succeeded++
+			}
+		}
+		assert.Equal(t, 1, succeeded)
+	})
+}
+
+func TestMemoryStoreResponses(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("ReturnsEmptyWhenNoResponseSaved", func(t *testing.T) {
+		s := ssp.NewMemoryStore()
+		response, err := s.GetResponse(ctx, sqrl.Nut("neverusedbefore"))
+		assert.NoError(t, err)
+		assert.Empty(t, response)
+	})
+
+	t.Run("ReturnsTheSavedResponse", func(t *testing.T) {
+		s := ssp.NewMemoryStore()
+		_ = s.SaveResponse(ctx, sqrl.Nut("somenut"), "someresponse")
+		response, err := s.GetResponse(ctx, sqrl.Nut("somenut"))
+		assert.NoError(t, err)
+		assert.Equal(t, "someresponse", response)
+	})
+
+	t.Run("ForgetsResponsesOnceTheirNutHasExpired", func(t *testing.T) {
+		veryShortExpiry := time.Millisecond
+		s := ssp.NewMemoryStoreWithNutExpiry(veryShortExpiry)
+		_ = s.SaveResponse(ctx, sqrl.Nut("oldnut"), "oldresponse")
+
+		time.Sleep(veryShortExpiry * 3)
+
+		_ = s.SaveResponse(ctx, sqrl.Nut("newnut"), "newresponse")
+		response, err := s.GetResponse(ctx, sqrl.Nut("oldnut"))
+		assert.NoError(t, err)
+		assert.Empty(t, response)
+		response, err = s.GetResponse(ctx, sqrl.Nut("newnut"))
+		assert.NoError(t, err)
+		assert.Equal(t, "newresponse", response)
+	})
+}
+
 func TestMemoryStoreIdent(t *testing.T) {
 	ctx := context.TODO()
 	knownNut := sqrl.Nut("somenut")
@@ -152,4 +243,64 @@ func TestMemoryStoreUsers(t *testing.T) {
 		assert.Nil(t, err)
 		assert.Nil(t, fetchedUser)
 	})
+
+	t.Run("SaveUserPersistsChanges", func(t *testing.T) {
+		s := ssp.NewMemoryStore()
+		idk := sqrl.Identity("someidk")
+		user, _ := s.CreateUser(ctx, idk)
+
+		user.Disabled = true
+		user.Suk = sqrl.Identity("somesuk")
+		user.Vuk = sqrl.Identity("somevuk")
+		err := s.SaveUser(ctx, user)
+		assert.Nil(t, err)
+
+		fetchedUser, _ := s.GetUserByIdentity(ctx, idk)
+		if assert.NotNil(t, fetchedUser) {
+			assert.True(t, fetchedUser.Disabled)
+			assert.Equal(t, sqrl.Identity("somesuk"), fetchedUser.Suk)
+			assert.Equal(t, sqrl.Identity("somevuk"), fetchedUser.Vuk)
+		}
+	})
+
+	t.Run("ChangesAreNotPersistedWithoutSaveUser", func(t *testing.T) {
+		s := ssp.NewMemoryStore()
+		idk := sqrl.Identity("someidk")
+		user, _ := s.CreateUser(ctx, idk)
+		user.Disabled = true
+
+		fetchedUser, _ := s.GetUserByIdentity(ctx, idk)
+		if assert.NotNil(t, fetchedUser) {
+			assert.False(t, fetchedUser.Disabled)
+		}
+	})
+
+	t.Run("SaveUserReturnsErrorIfUserNotFound", func(t *testing.T) {
+		s := ssp.NewMemoryStore()
+		err := s.SaveUser(ctx, &ssp.User{Id: "someuser"})
+		assert.Error(t, err)
+	})
+
+	t.Run("DeleteUserRemovesUser", func(t *testing.T) {
+		s := ssp.NewMemoryStore()
+		idk := sqrl.Identity("someidk")
+		user, _ := s.CreateUser(ctx, idk)
+		other, _ := s.CreateUser(ctx, "someotheridk")
+
+		err := s.DeleteUser(ctx, user.Id)
+		assert.Nil(t, err)
+
+		fetchedUser, _ := s.GetUserByIdentity(ctx, idk)
+		assert.Nil(t, fetchedUser)
+		fetchedOther, _ := s.GetUserByIdentity(ctx, "someotheridk")
+		if assert.NotNil(t, fetchedOther) {
+			assert.Equal(t, other.Id, fetchedOther.Id)
+		}
+	})
+
+	t.Run("DeleteUserReturnsNoErrorIfUserNotFound", func(t *testing.T) {
+		s := ssp.NewMemoryStore()
+		err := s.DeleteUser(ctx, "someuser")
+		assert.Nil(t, err)
+	})
 }
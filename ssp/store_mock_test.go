@@ -19,6 +19,35 @@ type mockStore struct {
 				Err         error
 			}
 		}
+		ConsumeNut struct {
+			CalledWith struct {
+				Ctx context.Context
+				Nut sqrl.Nut
+			}
+			Returns struct {
+				Err error
+			}
+		}
+		SaveResponse struct {
+			CalledWith struct {
+				Ctx      context.Context
+				Nut      sqrl.Nut
+				Response string
+			}
+			Returns struct {
+				Err error
+			}
+		}
+		GetResponse struct {
+			CalledWith struct {
+				Ctx context.Context
+				Nut sqrl.Nut
+			}
+			Returns struct {
+				Response string
+				Err      error
+			}
+		}
 		SaveTransaction struct {
 			CalledWith struct {
 				Ctx         context.Context
@@ -68,6 +97,24 @@ type mockStore struct {
 				Err  error
 			}
 		}
+		SaveUser struct {
+			CalledWith struct {
+				Ctx  context.Context
+				User *ssp.User
+			}
+			Returns struct {
+				Err error
+			}
+		}
+		DeleteUser struct {
+			CalledWith struct {
+				Ctx context.Context
+				Id  string
+			}
+			Returns struct {
+				Err error
+			}
+		}
 	}
 }
 
@@ -77,6 +124,25 @@ func (m *mockStore) GetFirstTransaction(ctx context.Context, nut sqrl.Nut) (*sqr
 	return m.Func.GetFirstTransaction.Returns.Transaction, m.Func.GetFirstTransaction.Returns.Err
 }
 
+func (m *mockStore) ConsumeNut(ctx context.Context, nut sqrl.Nut) error {
+	m.Func.ConsumeNut.CalledWith.Ctx = ctx
+	m.Func.ConsumeNut.CalledWith.Nut = nut
+	return m.Func.ConsumeNut.Returns.Err
+}
+
+func (m *mockStore) SaveResponse(ctx context.Context, nut sqrl.Nut, response string) error {
+	m.Func.SaveResponse.CalledWith.Ctx = ctx
+	m.Func.SaveResponse.CalledWith.Nut = nut
+	m.Func.SaveResponse.CalledWith.Response = response
+	return m.Func.SaveResponse.Returns.Err
+}
+
+func (m *mockStore) GetResponse(ctx context.Context, nut sqrl.Nut) (response string, err error) {
+	m.Func.GetResponse.CalledWith.Ctx = ctx
+	m.Func.GetResponse.CalledWith.Nut = nut
+	return m.Func.GetResponse.Returns.Response, m.Func.GetResponse.Returns.Err
+}
+
 func (m *mockStore) SaveTransaction(ctx context.Context, t *sqrl.Transaction) error {
 	m.Func.SaveTransaction.CalledWith.Ctx = ctx
 	m.Func.SaveTransaction.CalledWith.Transaction = t
@@ -108,6 +174,18 @@ func (m *mockStore) GetUserByIdentity(ctx context.Context, idk sqrl.Identity) (*
 	return m.Func.GetUserByIdentity.Returns.User, m.Func.GetUserByIdentity.Returns.Err
 }
 
+func (m *mockStore) SaveUser(ctx context.Context, user *ssp.User) error {
+	m.Func.SaveUser.CalledWith.Ctx = ctx
+	m.Func.SaveUser.CalledWith.User = user
+	return m.Func.SaveUser.Returns.Err
+}
+
+func (m *mockStore) DeleteUser(ctx context.Context, id string) error {
+	m.Func.DeleteUser.CalledWith.Ctx = ctx
+	m.Func.DeleteUser.CalledWith.Id = id
+	return m.Func.DeleteUser.Returns.Err
+}
+
 // Language helpers
 
 func NewStore() *mockStore {
@@ -128,3 +206,9 @@ func (m *mockStore) ReturnsKnownIdentity() *mockStore {
 	m.Func.GetUserByIdentity.Returns.Err = nil
 	return m
 }
+
+func (m *mockStore) ReturnsKnownUser(user *ssp.User) *mockStore {
+	m.Func.GetUserByIdentity.Returns.User = user
+	m.Func.GetUserByIdentity.Returns.Err = nil
+	return m
+}
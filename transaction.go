@@ -2,7 +2,7 @@ package sqrl
 
 import (
 	"errors"
-	"net/url"
+	"fmt"
 	"strings"
 )
 
@@ -14,6 +14,18 @@ var (
 	// ErrInvalidIDSig the identity signature parameter is not correct
 	// for the given identity key and payload.
 	ErrInvalidIDSig = errors.New("invalid identity signature")
+	// ErrInvalidPIDSig the previous identity signature parameter is
+	// missing or not correct for the given previous identity key and payload.
+	ErrInvalidPIDSig = errors.New("invalid previous identity signature")
+	// ErrMissingURS the unlock request signature parameter is required
+	// for the command but was not provided.
+	ErrMissingURS = errors.New("missing unlock request signature")
+	// ErrInvalidURS the unlock request signature parameter is not correct
+	// for the given verify unlock key and payload.
+	ErrInvalidURS = errors.New("invalid unlock request signature")
+	// ErrInvalidServerURL the SQRL URL signed by the client in the first
+	// request of a session was not issued by this server.
+	ErrInvalidServerURL = errors.New("server url does not match site")
 	// ErrIPMismatch the client IP address does not match the original
 	// transaction in the negotiation.
 	ErrIPMismatch = errors.New("ip does not match")
@@ -26,12 +38,32 @@ type Request struct {
 	Client string
 	Server string
 	Ids    Signature
-	// TODO: Pids
-	// TODO: Suk
+	Pids   Signature
+	Urs    Signature
 
 	ClientIP string
 }
 
+// Site describes the SQRL URLs issued by a server. It is used to check
+// that the URL signed by a client in the first request of a session was
+// issued by this server, rather than a client being tricked into signing
+// a URL for a different site.
+type Site struct {
+	// Hosts are the domains (including the port if it is
+	// not the default) that SQRL URLs are issued for.
+	Hosts []string
+	// Path is the client endpoint path that SQRL URLs
+	// are issued with eg. /sqrl/cli.sqrl
+	Path string
+	// Ext is the number of path characters that are
+	// included in the site key, the x= URL parameter.
+	// No x= parameter is expected if zero.
+	Ext int
+	// SFN is the server friendly name, the sfn= URL
+	// parameter. No sfn= parameter is expected if empty.
+	SFN string
+}
+
 // Transaction represents a SQRL request and response, a single
 // exchange in a SQRL negotiation. There are likely to be multiple
 // transactions for a single SQRL sign-in.
@@ -44,20 +76,72 @@ type Transaction struct {
 	*Request
 }
 
+// Identities are the identity keys of a request
+// whose signatures have been successfully verified.
+//
+// Previous is empty if the client did not assert
+// a previous identity key.
+type Identities struct {
+	Current  Identity
+	Previous Identity
+}
+
 // Verify checks that a request from a SQRL client is valid.
 //
 // The transaction that started this session should be provided if one exists.
 // If no previous transaction is provided, the request is presumed to be the
 // first request for this session.
 //
+// The exact encoded server message that was returned to the client alongside
+// req.Nut should be provided as lastResponse. The client must echo it back byte
+// for byte as the server parameter, this prevents a client from signing a
+// fabricated server message. If no response has been sent for the nut, the
+// request must be the first of the session and the server parameter must be
+// the SQRL URL that the nut was issued in. The URL must match the given site,
+// otherwise ErrInvalidServerURL is returned.
+//
 // Note: No attempt is made to verify the previous transaction (other than
 // to compare it's properties to those of the new transaction). It is assumed
 // that the previous transaction has already had it's signatures checked and
 // payload validated.
 //
+// If the client asserts a previous identity key (pidk), the previous identity
+// signature (pids) must also be valid for the same payload. When Verify
+// succeeds the Pidk of the returned client message can be trusted, allowing
+// servers to look up the previous identity and set TIFPreviousIDMatch.
+//
 // If a validation error is encoutered, the precise error will be returned and the
-// correct transaction information flags will be set on the response.
-func Verify(req *Request, first *Transaction, response *ServerMsg) (*ClientMsg, error) {
+// correct transaction information flags will be set on the response. If the client
+// parameter can not be parsed a *ParseError describing the problem is returned,
+// which unwraps to ErrInvalidClient; use errors.Is and errors.As to inspect it.
+//
+// Verify is made up of the ParseRequest, VerifyServer, VerifySignatures and
+// VerifySession stages, which can be used individually eg. to check the
+// signatures of a request before any state is retrieved for it.
+func Verify(req *Request, site Site, first *Transaction, lastResponse string, response *ServerMsg) (*ClientMsg, error) {
+	client, err := ParseRequest(req, response)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := VerifyServer(req, site, first, lastResponse, response); err != nil {
+		return nil, err
+	}
+	if _, err := VerifySignatures(req, client, response); err != nil {
+		return nil, err
+	}
+	if err := VerifySession(req, client, first, response); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// ParseRequest parses the client parameter of a request. The signatures
+// of the request have not yet been checked, so the values of the returned
+// client message must not be trusted until VerifySignatures succeeds.
+//
+// If the client parameter can not be parsed a *ParseError describing
+// the problem is returned, which unwraps to ErrInvalidClient.
+func ParseRequest(req *Request, response *ServerMsg) (*ClientMsg, error) {
 	if req.ClientIP == "" {
 		// ClientIP MUST always be set correctly for same-device protections
 		// to work correctly. We do not return an exported error here, because
@@ -67,24 +151,70 @@ func Verify(req *Request, first *Transaction, response *ServerMsg) (*ClientMsg,
 		return nil, errors.New("client ip should never be empty")
 	}
 
-	client, errc := ParseClient(req.Client)
-	if errc != nil {
-		response.Tif = response.Tif | TIFCommandFailed | TIFClientFailure
-		return nil, ErrInvalidClient
-	}
-	serverOK := verifyServer(req.Server, first)
-	if !serverOK {
-		response.Tif = response.Tif | TIFCommandFailed | TIFClientFailure
-		return nil, ErrInvalidServer
+	client, err := ParseClient(req.Client)
+	if err != nil {
+		var perr *ParseError
+		if errors.As(err, &perr) && perr.Reason == ReasonUnknownCommand {
+			response.Tif = response.Tif | TIFCommandFailed | TIFFunctionNotSupported
+		} else {
+			response.Tif = response.Tif | TIFCommandFailed | TIFClientFailure
+		}
+		if perr == nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidClient, err)
+		}
+		wrapped := *perr
+		wrapped.Err = ErrInvalidClient
+		return nil, &wrapped
 	}
+	return client, nil
+}
+
+// VerifySignatures checks the identity signature (ids) and, if the client
+// asserts a previous identity, the previous identity signature (pids) of a
+// request. The client message should be the one returned by ParseRequest.
+//
+// The signatures only prove that the client holds the identity keys, the
+// server parameter that was signed must still be checked with VerifyServer.
+//
+// The unlock request signature (urs) is not checked, as it can only be
+// verified with the verify unlock key stored by the server. Commands that
+// require it must check it separately with VerifyUnlock.
+func VerifySignatures(req *Request, client *ClientMsg, response *ServerMsg) (*Identities, error) {
 	signedPayload := req.Client + req.Server
 	if !req.Ids.Verify(client.Idk, signedPayload) {
 		response.Tif = response.Tif | TIFCommandFailed | TIFClientFailure
 		return nil, ErrInvalidIDSig
 	}
+	if client.Pidk != "" || req.Pids != "" {
+		if !req.Pids.Verify(client.Pidk, signedPayload) {
+			response.Tif = response.Tif | TIFCommandFailed | TIFClientFailure
+			return nil, ErrInvalidPIDSig
+		}
+	}
+	return &Identities{Current: client.Idk, Previous: client.Pidk}, nil
+}
+
+// VerifyServer checks that the server parameter of a request continues the
+// session, see Verify for the details of first and lastResponse. For the
+// first request of a session, the SQRL URL that was signed is returned.
+// For all other requests the returned URL is nil.
+func VerifyServer(req *Request, site Site, first *Transaction, lastResponse string, response *ServerMsg) (*URL, error) {
+	serverURL, err := verifyServer(req, site, first, lastResponse)
+	if err != nil {
+		response.Tif = response.Tif | TIFCommandFailed | TIFClientFailure
+		return nil, err
+	}
+	return serverURL, nil
+}
 
+// VerifySession checks that a request is bound to the session started by
+// the first transaction. TIFIPMatch is set on the response if the request
+// was made from the same IP address. If not, TIFCommandFailed and
+// TIFClientFailure are set and ErrIPMismatch is returned, unless the
+// client has set the noiptest option.
+func VerifySession(req *Request, client *ClientMsg, first *Transaction, response *ServerMsg) error {
 	if first == nil {
-		return client, nil
+		return nil
 	}
 
 	// TODO: Do we set IP Match for the first request? Presume not
@@ -94,49 +224,87 @@ func Verify(req *Request, first *Transaction, response *ServerMsg) (*ClientMsg,
 	ipMustMatch := !client.HasOpt(OptNoIPTest)
 	ipsMatch := response.Is(TIFIPMatch)
 	if ipMustMatch && !ipsMatch {
-		return nil, ErrIPMismatch
+		response.Tif = response.Tif | TIFCommandFailed | TIFClientFailure
+		return ErrIPMismatch
 	}
 
 	// TODO: Verify IDK Match
 
 	// TODO: Is cmd "ident" allowed if there is no previous transaction?
 
-	return client, nil
+	return nil
 }
 
-func verifyServer(serverRaw string, first *Transaction) bool {
-	bytes, err := Base64.DecodeString(serverRaw)
-	if err != nil {
-		return false
+// VerifyUnlock checks that the unlock request signature (urs) of a request
+// is valid for the verify unlock key (vuk) that was stored when the identity
+// was first associated with the server.
+//
+// The unlock request signature is required by the enable and remove commands
+// and should only be checked after the request has been checked with Verify.
+//
+// If the signature is missing or invalid, the precise error will be returned
+// and the correct transaction information flags will be set on the response.
+func VerifyUnlock(req *Request, vuk Identity, response *ServerMsg) error {
+	if req.Urs == "" {
+		response.Tif = response.Tif | TIFCommandFailed | TIFClientFailure
+		return ErrMissingURS
+	}
+	signedPayload := req.Client + req.Server
+	if !req.Urs.Verify(vuk, signedPayload) {
+		response.Tif = response.Tif | TIFCommandFailed | TIFClientFailure
+		return ErrInvalidURS
 	}
+	return nil
+}
 
-	server := string(bytes)
-	if strings.HasPrefix(server, "sqrl") {
-		if first != nil {
-			// Providing the previous query URL as the server
-			// param is ONLY valid for the first transaction
-			return false
+func verifyServer(req *Request, site Site, first *Transaction, lastResponse string) (*URL, error) {
+	if lastResponse != "" {
+		// Follow up requests must return exactly
+		// what we sent to the client last time.
+		if req.Server != lastResponse {
+			return nil, ErrInvalidServer
 		}
+		return nil, nil
+	}
+	if first != nil {
+		// Providing the previous query URL as the server
+		// param is ONLY valid for the first transaction
+		return nil, ErrInvalidServer
+	}
 
-		serverURL, err := url.Parse(server)
-		if err != nil {
-			return false
-		}
+	bytes, err := Base64.DecodeString(req.Server)
+	if err != nil {
+		return nil, ErrInvalidServer
+	}
+
+	serverURL, err := ParseURL(string(bytes))
+	if err != nil {
+		return nil, ErrInvalidServer
+	}
+
+	// The URL must be the one that the nut was issued in
+	if serverURL.Nut == "" || serverURL.Nut != req.Nut {
+		return nil, ErrInvalidServer
+	}
 
-		// TODO: Assert URL matches server configuration
-		// eg. domain, "server friendly name", etc.
+	if !site.matches(serverURL) {
+		return nil, ErrInvalidServerURL
+	}
+	return serverURL, nil
+}
 
-		nut := serverURL.Query().Get("nut")
-		return nut != ""
+func (s Site) matches(u *URL) bool {
+	return s.hasHost(u.Host) &&
+		u.Path == s.Path &&
+		u.Ext == s.Ext &&
+		u.SFN == s.SFN
+}
 
-	} else {
-		// TODO: We must be able to do something smarter with this
-		// We should be able to check the previous server val we sent
-		// to the client and compare this against that.
-		msg, err := ParseServer(serverRaw)
-		if err != nil || msg == nil || msg.Nut == "" {
-			return false
+func (s Site) hasHost(host string) bool {
+	for _, h := range s.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
 		}
-		return true
 	}
+	return false
 }
@@ -1,6 +1,7 @@
 package sqrl_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/RaniSputnik/sqrl-go"
@@ -8,6 +9,11 @@ import (
 	"golang.org/x/crypto/ed25519"
 )
 
+var exampleSite = sqrl.Site{
+	Hosts: []string{"example.com"},
+	Path:  "/sqrl",
+}
+
 func TestVerifyFirstTransaction(t *testing.T) {
 	alice, aliceSig := newIDKey()
 
@@ -27,49 +33,181 @@ func TestVerifyFirstTransaction(t *testing.T) {
 
 	t.Run("FailsWhenClientIPNotSet", func(t *testing.T) {
 		req := &sqrl.Request{
+			Nut:    "123456789",
 			Client: validClient,
 			Server: validServer,
 			Ids:    validIds,
 		}
 
-		_, err := sqrl.Verify(req, nil, newResponse())
+		_, err := sqrl.Verify(req, exampleSite, nil, "", newResponse())
 		assert.Error(t, err)
 	})
 
 	t.Run("FailsWhenClientParamIsMissing", func(t *testing.T) {
 		req := &sqrl.Request{
+			Nut:      "123456789",
 			Server:   validServer,
 			Ids:      validIds,
 			ClientIP: "10.0.0.1",
 		}
 
-		_, err := sqrl.Verify(req, nil, newResponse())
-		assert.Equal(t, sqrl.ErrInvalidClient, err)
+		_, err := sqrl.Verify(req, exampleSite, nil, "", newResponse())
+		assert.True(t, errors.Is(err, sqrl.ErrInvalidClient), "Expected ErrInvalidClient, got: %v", err)
+
+		var perr *sqrl.ParseError
+		if assert.True(t, errors.As(err, &perr), "Expected a *ParseError, got: %v", err) {
+			assert.Equal(t, "client", perr.Field)
+			assert.Equal(t, sqrl.ReasonMissing, perr.Reason)
+		}
+	})
+
+	t.Run("SetsFunctionNotSupportedForUnknownCommand", func(t *testing.T) {
+		unknown := sqrl.Base64.EncodeToString([]byte("ver=1\ncmd=login\nidk=" + string(alice) + "\n"))
+		req := &sqrl.Request{
+			Nut:      "123456789",
+			Client:   unknown,
+			Server:   validServer,
+			Ids:      signature(aliceSig, unknown+validServer),
+			ClientIP: "10.0.0.1",
+		}
+
+		response := newResponse()
+		_, err := sqrl.Verify(req, exampleSite, nil, "", response)
+		assert.True(t, errors.Is(err, sqrl.ErrInvalidClient), "Expected ErrInvalidClient, got: %v", err)
+		assert.True(t, response.Is(sqrl.TIFCommandFailed))
+		assert.True(t, response.Is(sqrl.TIFFunctionNotSupported))
+		assert.False(t, response.Is(sqrl.TIFClientFailure))
 	})
 
 	t.Run("FailsWhenServerParamIsMissing", func(t *testing.T) {
 		req := &sqrl.Request{
+			Nut:      "123456789",
 			Client:   validClient,
 			Ids:      validIds,
 			ClientIP: "10.0.0.1",
 		}
 
-		_, err := sqrl.Verify(req, nil, newResponse())
+		_, err := sqrl.Verify(req, exampleSite, nil, "", newResponse())
 		assert.Equal(t, sqrl.ErrInvalidServer, err)
 	})
 
 	// TODO: Do we fail when there's no previous transaction
 	// and the cmd is ident? Shouldn't there always be a query first?
 
+	t.Run("FailsWhenServerURLIsForAnotherNut", func(t *testing.T) {
+		req := &sqrl.Request{
+			Nut:      "anothernut",
+			Client:   validClient,
+			Server:   validServer,
+			Ids:      validIds,
+			ClientIP: "10.0.0.1",
+		}
+
+		_, err := sqrl.Verify(req, exampleSite, nil, "", newResponse())
+		assert.Equal(t, sqrl.ErrInvalidServer, err)
+	})
+
+	t.Run("FailsWhenServerIsNotAURLAndNoResponseWasSent", func(t *testing.T) {
+		fabricated, _ := (&sqrl.ServerMsg{
+			Ver: []string{sqrl.V1},
+			Nut: "123456789",
+			Qry: "/sqrl?nut=123456789",
+		}).Encode()
+		req := &sqrl.Request{
+			Nut:      "123456789",
+			Client:   validClient,
+			Server:   fabricated,
+			Ids:      signature(aliceSig, validClient+fabricated),
+			ClientIP: "10.0.0.1",
+		}
+
+		_, err := sqrl.Verify(req, exampleSite, nil, "", newResponse())
+		assert.Equal(t, sqrl.ErrInvalidServer, err)
+	})
+
+	t.Run("FailsWhenServerURLDoesNotMatchSite", func(t *testing.T) {
+		tests := map[string]string{
+			"OtherHost":        "sqrl://evil.example.com/sqrl?nut=123456789",
+			"OtherPort":        "sqrl://example.com:8080/sqrl?nut=123456789",
+			"UserInfo":         "sqrl://example.com@evil.example.com/sqrl?nut=123456789",
+			"OtherPath":        "sqrl://example.com/other?nut=123456789",
+			"UnexpectedExt":    "sqrl://example.com/sqrl?nut=123456789&x=5",
+			"UnexpectedSFN":    "sqrl://example.com/sqrl?nut=123456789&sfn=" + sqrl.Base64.EncodeToString([]byte("Evil")),
+			"OtherScheme":      "https://example.com/sqrl?nut=123456789",
+			"NoSchemeOrDomain": "/sqrl?nut=123456789",
+		}
+
+		for name, serverURL := range tests {
+			t.Run(name, func(t *testing.T) {
+				server := sqrl.Base64.EncodeToString([]byte(serverURL))
+				req := &sqrl.Request{
+					Nut:      "123456789",
+					Client:   validClient,
+					Server:   server,
+					Ids:      signature(aliceSig, validClient+server),
+					ClientIP: "10.0.0.1",
+				}
+
+				response := newResponse()
+				_, err := sqrl.Verify(req, exampleSite, nil, "", response)
+				assert.Error(t, err)
+				assert.True(t, response.Is(sqrl.TIFCommandFailed))
+				assert.True(t, response.Is(sqrl.TIFClientFailure))
+			})
+		}
+	})
+
+	t.Run("ReturnsErrInvalidServerURLForAnotherSite", func(t *testing.T) {
+		server := sqrl.Base64.EncodeToString([]byte("sqrl://evil.example.com/sqrl?nut=123456789"))
+		req := &sqrl.Request{
+			Nut:      "123456789",
+			Client:   validClient,
+			Server:   server,
+			Ids:      signature(aliceSig, validClient+server),
+			ClientIP: "10.0.0.1",
+		}
+
+		_, err := sqrl.Verify(req, exampleSite, nil, "", newResponse())
+		assert.Equal(t, sqrl.ErrInvalidServerURL, err)
+	})
+
+	t.Run("AcceptsServerURLMatchingSite", func(t *testing.T) {
+		site := sqrl.Site{
+			Hosts: []string{"www.example.com", "example.com:8080"},
+			Path:  "/user/sqrl",
+			Ext:   5,
+			SFN:   "Example",
+		}
+		tests := []string{
+			"sqrl://www.example.com/user/sqrl?nut=123456789&x=5&sfn=" + sqrl.Base64.EncodeToString([]byte("Example")),
+			"sqrl://EXAMPLE.com:8080/user/sqrl?sfn=" + sqrl.Base64.EncodeToString([]byte("Example")) + "&x=5&nut=123456789",
+		}
+
+		for _, serverURL := range tests {
+			server := sqrl.Base64.EncodeToString([]byte(serverURL))
+			req := &sqrl.Request{
+				Nut:      "123456789",
+				Client:   validClient,
+				Server:   server,
+				Ids:      signature(aliceSig, validClient+server),
+				ClientIP: "10.0.0.1",
+			}
+
+			_, err := sqrl.Verify(req, site, nil, "", newResponse())
+			assert.NoError(t, err, serverURL)
+		}
+	})
+
 	t.Run("FailsWhenIDSInvalid", func(t *testing.T) {
 		req := &sqrl.Request{
+			Nut:      "123456789",
 			Client:   validClient,
 			Server:   validServer,
 			Ids:      "invalid-sig",
 			ClientIP: "10.0.0.1",
 		}
 
-		_, err := sqrl.Verify(req, nil, newResponse())
+		_, err := sqrl.Verify(req, exampleSite, nil, "", newResponse())
 		assert.Equal(t, sqrl.ErrInvalidIDSig, err)
 	})
 
@@ -78,30 +216,114 @@ func TestVerifyFirstTransaction(t *testing.T) {
 		invalidIds := signature(aliceSig, wrongPayload)
 
 		req := &sqrl.Request{
+			Nut:      "123456789",
 			Client:   validClient,
 			Server:   validServer,
 			Ids:      invalidIds,
 			ClientIP: "10.0.0.1",
 		}
 
-		_, err := sqrl.Verify(req, nil, newResponse())
+		_, err := sqrl.Verify(req, exampleSite, nil, "", newResponse())
 		assert.Equal(t, sqrl.ErrInvalidIDSig, err)
 	})
 
 	t.Run("ReturnsParsedClientForAValidRequest", func(t *testing.T) {
 		req := &sqrl.Request{
+			Nut:      "123456789",
 			Client:   validClient,
 			Server:   validServer,
 			Ids:      validIds,
 			ClientIP: "10.0.0.1",
 		}
 
-		gotClient, err := sqrl.Verify(req, nil, newResponse())
+		gotClient, err := sqrl.Verify(req, exampleSite, nil, "", newResponse())
 		assert.NoError(t, err)
 		assert.Equal(t, *c, *gotClient)
 	})
 }
 
+func TestVerifyPreviousIdentity(t *testing.T) {
+	alice, aliceSig := newIDKey()
+	alicePrevious, alicePreviousSig := newIDKey()
+
+	c := &sqrl.ClientMsg{
+		Ver:  []string{sqrl.V1},
+		Cmd:  sqrl.CmdQuery,
+		Idk:  alice,
+		Pidk: alicePrevious,
+		Opt:  []sqrl.Opt{},
+	}
+	validClient, _ := c.Encode()
+	validServer := sqrl.Base64.EncodeToString([]byte("sqrl://example.com/sqrl?nut=123456789"))
+	validIds := signature(aliceSig, validClient+validServer)
+	validPids := signature(alicePreviousSig, validClient+validServer)
+
+	t.Run("FailsWhenPidsIsMissing", func(t *testing.T) {
+		req := &sqrl.Request{
+			Nut:      "123456789",
+			Client:   validClient,
+			Server:   validServer,
+			Ids:      validIds,
+			ClientIP: "10.0.0.1",
+		}
+
+		response := &sqrl.ServerMsg{}
+		_, err := sqrl.Verify(req, exampleSite, nil, "", response)
+		assert.Equal(t, sqrl.ErrInvalidPIDSig, err)
+		assert.True(t, response.Is(sqrl.TIFClientFailure))
+	})
+
+	t.Run("FailsWhenPidsSignedByCurrentIdentity", func(t *testing.T) {
+		req := &sqrl.Request{
+			Nut:      "123456789",
+			Client:   validClient,
+			Server:   validServer,
+			Ids:      validIds,
+			Pids:     validIds,
+			ClientIP: "10.0.0.1",
+		}
+
+		_, err := sqrl.Verify(req, exampleSite, nil, "", &sqrl.ServerMsg{})
+		assert.Equal(t, sqrl.ErrInvalidPIDSig, err)
+	})
+
+	t.Run("FailsWhenPidsProvidedWithoutPidk", func(t *testing.T) {
+		withoutPidk := &sqrl.ClientMsg{
+			Ver: []string{sqrl.V1},
+			Cmd: sqrl.CmdQuery,
+			Idk: alice,
+		}
+		client, _ := withoutPidk.Encode()
+		req := &sqrl.Request{
+			Nut:      "123456789",
+			Client:   client,
+			Server:   validServer,
+			Ids:      signature(aliceSig, client+validServer),
+			Pids:     signature(alicePreviousSig, client+validServer),
+			ClientIP: "10.0.0.1",
+		}
+
+		_, err := sqrl.Verify(req, exampleSite, nil, "", &sqrl.ServerMsg{})
+		assert.Equal(t, sqrl.ErrInvalidPIDSig, err)
+	})
+
+	t.Run("ReturnsParsedClientWithPreviousIdentity", func(t *testing.T) {
+		req := &sqrl.Request{
+			Nut:      "123456789",
+			Client:   validClient,
+			Server:   validServer,
+			Ids:      validIds,
+			Pids:     validPids,
+			ClientIP: "10.0.0.1",
+		}
+
+		gotClient, err := sqrl.Verify(req, exampleSite, nil, "", &sqrl.ServerMsg{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, alicePrevious, gotClient.Pidk)
+		}
+	})
+}
+
 func TestVerifyWithPreviousTransaction(t *testing.T) {
 	alice, aliceSig := newIDKey()
 
@@ -150,7 +372,52 @@ func TestVerifyWithPreviousTransaction(t *testing.T) {
 			ClientIP: "10.0.0.1",
 		}
 
-		_, err := sqrl.Verify(req, prevTransaction, newResponse())
+		_, err := sqrl.Verify(req, exampleSite, prevTransaction, validServerIdent, newResponse())
+		assert.Equal(t, sqrl.ErrInvalidServer, err)
+	})
+
+	t.Run("FailsWhenServerDoesNotMatchLastResponse", func(t *testing.T) {
+		prevRequest := &sqrl.Request{
+			Client:   validClientQuery,
+			Server:   validServerQuery,
+			Ids:      signature(aliceSig, validClientQuery+validServerQuery),
+			ClientIP: "10.0.0.1",
+		}
+		prevTransaction := &sqrl.Transaction{Request: prevRequest}
+
+		tampered := *serverIdent
+		tampered.Tif = sqrl.TIFCurrentIDMatch
+		tamperedServerIdent, _ := tampered.Encode()
+		req := &sqrl.Request{
+			Client:   validClientIdent,
+			Server:   tamperedServerIdent,
+			Ids:      signature(aliceSig, validClientIdent+tamperedServerIdent),
+			ClientIP: "10.0.0.1",
+		}
+
+		response := newResponse()
+		_, err := sqrl.Verify(req, exampleSite, prevTransaction, validServerIdent, response)
+		assert.Equal(t, sqrl.ErrInvalidServer, err)
+		assert.True(t, response.Is(sqrl.TIFClientFailure))
+	})
+
+	t.Run("FailsWhenPreviousTransactionExistsButNoResponseWasSent", func(t *testing.T) {
+		prevRequest := &sqrl.Request{
+			Client:   validClientQuery,
+			Server:   validServerQuery,
+			Ids:      signature(aliceSig, validClientQuery+validServerQuery),
+			ClientIP: "10.0.0.1",
+		}
+		prevTransaction := &sqrl.Transaction{Request: prevRequest}
+
+		req := &sqrl.Request{
+			Client:   validClientIdent,
+			Server:   validServerIdent,
+			Ids:      signature(aliceSig, validClientIdent+validServerIdent),
+			ClientIP: "10.0.0.1",
+		}
+
+		_, err := sqrl.Verify(req, exampleSite, prevTransaction, "", newResponse())
 		assert.Equal(t, sqrl.ErrInvalidServer, err)
 	})
 
@@ -170,8 +437,11 @@ func TestVerifyWithPreviousTransaction(t *testing.T) {
 			ClientIP: "10.0.0.2",
 		}
 
-		_, err := sqrl.Verify(req, prevTransaction, newResponse())
+		response := newResponse()
+		_, err := sqrl.Verify(req, exampleSite, prevTransaction, validServerIdent, response)
 		assert.Equal(t, sqrl.ErrIPMismatch, err)
+		assert.True(t, response.Is(sqrl.TIFCommandFailed))
+		assert.True(t, response.Is(sqrl.TIFClientFailure))
 	})
 
 	t.Run("ReturnsNoErrorWhenClientIPDoesNotMatchButNoIPTestOptIsSet", func(t *testing.T) {
@@ -205,7 +475,7 @@ func TestVerifyWithPreviousTransaction(t *testing.T) {
 			ClientIP: "10.0.0.2",
 		}
 
-		gotClient, err := sqrl.Verify(req, prevTransaction, newResponse())
+		gotClient, err := sqrl.Verify(req, exampleSite, prevTransaction, validServerIdent, newResponse())
 		if assert.NoError(t, err) {
 			assert.Equal(t, *clientIdent, *gotClient)
 		}
@@ -235,11 +505,11 @@ func TestVerifyWithPreviousTransaction(t *testing.T) {
 		}
 
 		resMatch := newResponse()
-		_, _ = sqrl.Verify(match, prevTransaction, resMatch)
+		_, _ = sqrl.Verify(match, exampleSite, prevTransaction, validServerIdent, resMatch)
 		assert.True(t, resMatch.Is(sqrl.TIFIPMatch), "IP Match should be set")
 
 		resNonMatch := newResponse()
-		_, _ = sqrl.Verify(nonMatch, prevTransaction, resNonMatch)
+		_, _ = sqrl.Verify(nonMatch, exampleSite, prevTransaction, validServerIdent, resNonMatch)
 		assert.False(t, resNonMatch.Is(sqrl.TIFIPMatch), "IP Match should not be set")
 	})
 
@@ -263,13 +533,181 @@ func TestVerifyWithPreviousTransaction(t *testing.T) {
 			ClientIP: "10.0.0.1",
 		}
 
-		gotClient, err := sqrl.Verify(req, prevTransaction, newResponse())
+		gotClient, err := sqrl.Verify(req, exampleSite, prevTransaction, validServerIdent, newResponse())
 		if assert.NoError(t, err) {
 			assert.Equal(t, *clientIdent, *gotClient)
 		}
 	})
 }
 
+func TestVerifyStages(t *testing.T) {
+	alice, aliceSig := newIDKey()
+	alicePrevious, alicePreviousSig := newIDKey()
+
+	c := &sqrl.ClientMsg{
+		Ver:  []string{sqrl.V1},
+		Cmd:  sqrl.CmdQuery,
+		Idk:  alice,
+		Pidk: alicePrevious,
+	}
+	validClient, _ := c.Encode()
+	validServer := sqrl.Base64.EncodeToString([]byte("sqrl://example.com/sqrl?nut=123456789"))
+	validReq := func() *sqrl.Request {
+		return &sqrl.Request{
+			Nut:      "123456789",
+			Client:   validClient,
+			Server:   validServer,
+			Ids:      signature(aliceSig, validClient+validServer),
+			Pids:     signature(alicePreviousSig, validClient+validServer),
+			ClientIP: "10.0.0.1",
+		}
+	}
+
+	t.Run("ParseRequestReturnsUnverifiedClient", func(t *testing.T) {
+		req := validReq()
+		req.Ids = "invalid"
+
+		client, err := sqrl.ParseRequest(req, &sqrl.ServerMsg{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, alice, client.Idk)
+		}
+	})
+
+	t.Run("VerifySignaturesReturnsIdentities", func(t *testing.T) {
+		req := validReq()
+		client, _ := sqrl.ParseRequest(req, &sqrl.ServerMsg{})
+
+		got, err := sqrl.VerifySignatures(req, client, &sqrl.ServerMsg{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, &sqrl.Identities{Current: alice, Previous: alicePrevious}, got)
+		}
+	})
+
+	t.Run("VerifySignaturesFailsWithoutCheckingServer", func(t *testing.T) {
+		req := validReq()
+		client, _ := sqrl.ParseRequest(req, &sqrl.ServerMsg{})
+		req.Ids = signature(aliceSig, validClient)
+
+		response := &sqrl.ServerMsg{}
+		_, err := sqrl.VerifySignatures(req, client, response)
+		assert.Equal(t, sqrl.ErrInvalidIDSig, err)
+		assert.True(t, response.Is(sqrl.TIFClientFailure))
+	})
+
+	t.Run("VerifyServerReturnsURLOfFirstRequest", func(t *testing.T) {
+		got, err := sqrl.VerifyServer(validReq(), exampleSite, nil, "", &sqrl.ServerMsg{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "example.com", got.Host)
+			assert.Equal(t, sqrl.Nut("123456789"), got.Nut)
+		}
+	})
+
+	t.Run("VerifyServerReturnsNoURLForFollowUpRequest", func(t *testing.T) {
+		req := validReq()
+		got, err := sqrl.VerifyServer(req, exampleSite, &sqrl.Transaction{Request: req}, req.Server, &sqrl.ServerMsg{})
+		assert.NoError(t, err)
+		assert.Nil(t, got)
+	})
+
+	t.Run("VerifySessionIgnoresFirstRequest", func(t *testing.T) {
+		req := validReq()
+		client, _ := sqrl.ParseRequest(req, &sqrl.ServerMsg{})
+
+		response := &sqrl.ServerMsg{}
+		err := sqrl.VerifySession(req, client, nil, response)
+		assert.NoError(t, err)
+		assert.False(t, response.Is(sqrl.TIFIPMatch))
+	})
+
+	t.Run("VerifySessionFailsWhenIPDoesNotMatch", func(t *testing.T) {
+		req := validReq()
+		client, _ := sqrl.ParseRequest(req, &sqrl.ServerMsg{})
+		first := &sqrl.Transaction{Request: &sqrl.Request{ClientIP: "10.0.0.2"}}
+
+		response := &sqrl.ServerMsg{}
+		err := sqrl.VerifySession(req, client, first, response)
+		assert.Equal(t, sqrl.ErrIPMismatch, err)
+		assert.True(t, response.Is(sqrl.TIFCommandFailed))
+		assert.True(t, response.Is(sqrl.TIFClientFailure))
+		assert.False(t, response.Is(sqrl.TIFIPMatch))
+	})
+}
+
+func TestVerifyUnlock(t *testing.T) {
+	alice, _ := newIDKey()
+	aliceVuk, aliceUrs := newIDKey()
+
+	c := &sqrl.ClientMsg{
+		Ver: []string{sqrl.V1},
+		Cmd: sqrl.CmdEnable,
+		Idk: alice,
+	}
+	validClient, _ := c.Encode()
+	validServer := sqrl.Base64.EncodeToString([]byte("sqrl://example.com/sqrl?nut=123456789"))
+	validUrs := signature(aliceUrs, validClient+validServer)
+
+	t.Run("FailsWhenUrsIsMissing", func(t *testing.T) {
+		req := &sqrl.Request{
+			Client: validClient,
+			Server: validServer,
+		}
+
+		response := &sqrl.ServerMsg{}
+		err := sqrl.VerifyUnlock(req, aliceVuk, response)
+		assert.Equal(t, sqrl.ErrMissingURS, err)
+		assert.True(t, response.Is(sqrl.TIFClientFailure))
+	})
+
+	t.Run("FailsWhenUrsDoesNotMatchVuk", func(t *testing.T) {
+		otherVuk, _ := newIDKey()
+		req := &sqrl.Request{
+			Client: validClient,
+			Server: validServer,
+			Urs:    validUrs,
+		}
+
+		response := &sqrl.ServerMsg{}
+		err := sqrl.VerifyUnlock(req, otherVuk, response)
+		assert.Equal(t, sqrl.ErrInvalidURS, err)
+		assert.True(t, response.Is(sqrl.TIFClientFailure))
+	})
+
+	t.Run("FailsWhenUrsSignedWrongPayload", func(t *testing.T) {
+		req := &sqrl.Request{
+			Client: validClient,
+			Server: validServer,
+			Urs:    signature(aliceUrs, validClient),
+		}
+
+		err := sqrl.VerifyUnlock(req, aliceVuk, &sqrl.ServerMsg{})
+		assert.Equal(t, sqrl.ErrInvalidURS, err)
+	})
+
+	t.Run("FailsWhenVukIsEmpty", func(t *testing.T) {
+		req := &sqrl.Request{
+			Client: validClient,
+			Server: validServer,
+			Urs:    validUrs,
+		}
+
+		err := sqrl.VerifyUnlock(req, "", &sqrl.ServerMsg{})
+		assert.Equal(t, sqrl.ErrInvalidURS, err)
+	})
+
+	t.Run("ReturnsNoErrorForValidUrs", func(t *testing.T) {
+		req := &sqrl.Request{
+			Client: validClient,
+			Server: validServer,
+			Urs:    validUrs,
+		}
+
+		response := &sqrl.ServerMsg{}
+		err := sqrl.VerifyUnlock(req, aliceVuk, response)
+		assert.NoError(t, err)
+		assert.False(t, response.Is(sqrl.TIFCommandFailed))
+	})
+}
+
 func newIDKey() (sqrl.Identity, []byte) {
 	pub, priv, err := ed25519.GenerateKey(nil)
 	if err != nil {
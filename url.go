@@ -0,0 +1,137 @@
+package sqrl
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidURL the given URL is not a valid SQRL link.
+var ErrInvalidURL = errors.New("invalid sqrl url")
+
+// URL is a SQRL link, as presented to a user in a QR code
+// or hyperlink. The client answers the link by posting to
+// the endpoint it refers to.
+type URL struct {
+	// Scheme is either sqrl or qrl. If not set, sqrl is used.
+	Scheme string
+	// Host is the domain the link was issued for, including
+	// the port if it is not the default.
+	Host string
+	// Path is the path of the client endpoint eg. /sqrl/cli.sqrl
+	Path string
+
+	// Nut is the nut the link was issued with.
+	Nut Nut
+	// Ext is the number of path characters that are
+	// included in the site key domain, the x parameter.
+	Ext int
+	// SFN is the server friendly name that the client
+	// displays to the user.
+	SFN string
+	// Can is the URL a client should redirect the
+	// browser to if the user cancels authentication.
+	Can string
+}
+
+// ParseURL parses a sqrl:// or qrl:// link.
+func ParseURL(raw string) (*URL, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	if parsed.Scheme != Scheme && parsed.Scheme != QRLScheme {
+		return nil, ErrInvalidURL
+	}
+	// Username and password are not permitted, they
+	// can be used to disguise the domain of the link.
+	if parsed.Host == "" || parsed.User != nil {
+		return nil, ErrInvalidURL
+	}
+
+	query := parsed.Query()
+	u := &URL{
+		Scheme: parsed.Scheme,
+		Host:   parsed.Host,
+		Path:   parsed.Path,
+		Nut:    Nut(query.Get("nut")),
+	}
+
+	if x := query.Get("x"); x != "" {
+		u.Ext, err = strconv.Atoi(x)
+		if err != nil || u.Ext < 0 || u.Ext > len(u.Path) {
+			return nil, ErrInvalidURL
+		}
+	}
+	if u.SFN, err = decodeParam(query.Get("sfn")); err != nil {
+		return nil, ErrInvalidURL
+	}
+	if u.Can, err = decodeParam(query.Get("can")); err != nil {
+		return nil, ErrInvalidURL
+	}
+
+	return u, nil
+}
+
+// String returns the link in the form that
+// it should be presented to the user.
+func (u *URL) String() string {
+	scheme := u.Scheme
+	if scheme == "" {
+		scheme = Scheme
+	}
+	return scheme + "://" + u.Host + u.RequestURI()
+}
+
+// RequestURI returns the path and query of the link,
+// the form used by the qry parameter of a server message.
+func (u *URL) RequestURI() string {
+	vals := []string{}
+	vals = appendIfSet(vals, "nut", string(u.Nut))
+	if u.Ext > 0 {
+		vals = append(vals, "x="+strconv.Itoa(u.Ext))
+	}
+	if u.SFN != "" {
+		vals = append(vals, "sfn="+Base64.EncodeToString([]byte(u.SFN)))
+	}
+	if u.Can != "" {
+		vals = append(vals, "can="+Base64.EncodeToString([]byte(u.Can)))
+	}
+
+	if len(vals) == 0 {
+		return u.Path
+	}
+	return u.Path + "?" + strings.Join(vals, "&")
+}
+
+// Endpoint returns the URL the client should post to when
+// answering the link. sqrl:// links are answered over https
+// while qrl:// links are answered over plain http.
+func (u *URL) Endpoint() string {
+	scheme := "https"
+	if u.Scheme == QRLScheme {
+		scheme = "http"
+	}
+	return scheme + "://" + u.Host + u.RequestURI()
+}
+
+// SiteKeyDomain returns the string the client uses to derive the
+// site specific identity for the link. It is the lower case domain,
+// without any port, followed by the first Ext characters of the path.
+func (u *URL) SiteKeyDomain() string {
+	domain := strings.ToLower(u.Host)
+	if i := strings.LastIndex(domain, ":"); i > strings.LastIndex(domain, "]") {
+		domain = domain[:i]
+	}
+	ext := u.Ext
+	if ext > len(u.Path) {
+		ext = len(u.Path)
+	}
+	return domain + u.Path[:ext]
+}
+
+func decodeParam(val string) (string, error) {
+	decoded, err := Base64.DecodeString(val)
+	return string(decoded), err
+}
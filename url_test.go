@@ -0,0 +1,118 @@
+package sqrl_test
+
+import (
+	"testing"
+
+	"github.com/RaniSputnik/sqrl-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseURL(t *testing.T) {
+	t.Run("ParsesAllParameters", func(t *testing.T) {
+		raw := "sqrl://example.com/user/sqrl?nut=abc123&x=5" +
+			"&sfn=" + sqrl.Base64.EncodeToString([]byte("Example")) +
+			"&can=" + sqrl.Base64.EncodeToString([]byte("https://example.com/login"))
+
+		got, err := sqrl.ParseURL(raw)
+		if assert.NoError(t, err) {
+			assert.Equal(t, sqrl.URL{
+				Scheme: sqrl.Scheme,
+				Host:   "example.com",
+				Path:   "/user/sqrl",
+				Nut:    "abc123",
+				Ext:    5,
+				SFN:    "Example",
+				Can:    "https://example.com/login",
+			}, *got)
+		}
+	})
+
+	t.Run("ParsesQRLScheme", func(t *testing.T) {
+		got, err := sqrl.ParseURL("qrl://example.com:8080/cli.sqrl?nut=abc123")
+		if assert.NoError(t, err) {
+			assert.Equal(t, sqrl.QRLScheme, got.Scheme)
+			assert.Equal(t, "example.com:8080", got.Host)
+		}
+	})
+
+	tests := map[string]string{
+		"Empty":             "",
+		"OtherScheme":       "https://example.com/sqrl?nut=abc123",
+		"MissingHost":       "sqrl:///sqrl?nut=abc123",
+		"UserInfo":          "sqrl://user@example.com/sqrl?nut=abc123",
+		"NonNumericExt":     "sqrl://example.com/sqrl?nut=abc123&x=five",
+		"NegativeExt":       "sqrl://example.com/sqrl?nut=abc123&x=-1",
+		"ExtLongerThanPath": "sqrl://example.com/sqrl?nut=abc123&x=6",
+		"InvalidSFN":        "sqrl://example.com/sqrl?nut=abc123&sfn=!!!",
+		"InvalidCan":        "sqrl://example.com/sqrl?nut=abc123&can=!!!",
+	}
+	for name, raw := range tests {
+		t.Run("Rejects"+name, func(t *testing.T) {
+			_, err := sqrl.ParseURL(raw)
+			assert.Equal(t, sqrl.ErrInvalidURL, err)
+		})
+	}
+}
+
+func TestURLString(t *testing.T) {
+	t.Run("DefaultsToSQRLScheme", func(t *testing.T) {
+		u := sqrl.URL{Host: "example.com", Path: "/cli.sqrl", Nut: "abc123"}
+		assert.Equal(t, "sqrl://example.com/cli.sqrl?nut=abc123", u.String())
+	})
+
+	t.Run("EncodesAllParameters", func(t *testing.T) {
+		u := sqrl.URL{
+			Scheme: sqrl.QRLScheme,
+			Host:   "example.com",
+			Path:   "/user/sqrl",
+			Nut:    "abc123",
+			Ext:    5,
+			SFN:    "Example",
+			Can:    "https://example.com/login",
+		}
+		expected := "qrl://example.com/user/sqrl?nut=abc123&x=5" +
+			"&sfn=" + sqrl.Base64.EncodeToString([]byte("Example")) +
+			"&can=" + sqrl.Base64.EncodeToString([]byte("https://example.com/login"))
+		assert.Equal(t, expected, u.String())
+
+		parsed, err := sqrl.ParseURL(u.String())
+		if assert.NoError(t, err) {
+			assert.Equal(t, u, *parsed)
+		}
+	})
+}
+
+func TestURLRequestURI(t *testing.T) {
+	u := sqrl.URL{Path: "/cli.sqrl", Nut: "abc123"}
+	assert.Equal(t, "/cli.sqrl?nut=abc123", u.RequestURI())
+	assert.Equal(t, "/cli.sqrl", (&sqrl.URL{Path: "/cli.sqrl"}).RequestURI())
+}
+
+func TestURLEndpoint(t *testing.T) {
+	t.Run("MapsSQRLToHTTPS", func(t *testing.T) {
+		u, _ := sqrl.ParseURL("sqrl://example.com/cli.sqrl?nut=abc123")
+		assert.Equal(t, "https://example.com/cli.sqrl?nut=abc123", u.Endpoint())
+	})
+
+	t.Run("MapsQRLToHTTP", func(t *testing.T) {
+		u, _ := sqrl.ParseURL("qrl://example.com:8080/cli.sqrl?nut=abc123")
+		assert.Equal(t, "http://example.com:8080/cli.sqrl?nut=abc123", u.Endpoint())
+	})
+}
+
+func TestURLSiteKeyDomain(t *testing.T) {
+	tests := map[string]string{
+		"sqrl://example.com/cli.sqrl?nut=abc123":               "example.com",
+		"sqrl://WWW.Example.com/cli.sqrl?nut=abc123":           "www.example.com",
+		"sqrl://example.com:8080/cli.sqrl?nut=abc123":          "example.com",
+		"sqrl://example.com/User/sqrl?nut=abc123&x=5":          "example.com/User",
+		"sqrl://[::1]:8080/cli.sqrl?nut=abc123":                "[::1]",
+		"sqrl://Example.com:8080/user/cli.sqrl?nut=abc123&x=5": "example.com/user",
+	}
+	for raw, expected := range tests {
+		u, err := sqrl.ParseURL(raw)
+		if assert.NoError(t, err, raw) {
+			assert.Equal(t, expected, u.SiteKeyDomain(), raw)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package sqrl
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNoCommonVersion the client and server do not
+// support any of the same versions of the protocol.
+var ErrNoCommonVersion = errors.New("no common version")
+
+// SupportedVersions are the versions of the
+// protocol that are implemented by this package.
+var SupportedVersions = Versions{{Min: 1, Max: 1}}
+
+// VersionRange is an inclusive range of protocol versions.
+type VersionRange struct {
+	Min int
+	Max int
+}
+
+// Versions is a set of protocol versions, as listed by the
+// ver parameter of a client or server message. The spec
+// allows both single versions and ranges eg. 1,3-5
+type Versions []VersionRange
+
+// ParseVersions parses a comma separated list of versions
+// and version ranges. If the list is invalid a *ParseError
+// is returned.
+func ParseVersions(raw string) (Versions, error) {
+	if raw == "" {
+		return nil, &ParseError{Field: "ver", Reason: ReasonMissing}
+	}
+
+	parts := strings.Split(raw, ",")
+	versions := make(Versions, 0, len(parts))
+	for _, part := range parts {
+		bounds := strings.SplitN(part, "-", 2)
+		min, err := parseVersion(bounds[0])
+		if err != nil {
+			return nil, &ParseError{Field: "ver", Reason: ReasonMalformed, Value: raw}
+		}
+		max := min
+		if len(bounds) == 2 {
+			if max, err = parseVersion(bounds[1]); err != nil || max < min {
+				return nil, &ParseError{Field: "ver", Reason: ReasonMalformed, Value: raw}
+			}
+		}
+		versions = append(versions, VersionRange{Min: min, Max: max})
+	}
+	return versions, nil
+}
+
+func parseVersion(input string) (int, error) {
+	v, err := strconv.Atoi(input)
+	if err != nil || v < 1 {
+		return 0, errors.New("invalid version")
+	}
+	return v, nil
+}
+
+// Contains returns whether or not the given version is in the set.
+func (v Versions) Contains(version int) bool {
+	for _, r := range v {
+		if version >= r.Min && version <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// Intersect returns the versions that are
+// in both this set and the given set.
+func (v Versions) Intersect(other Versions) Versions {
+	var res Versions
+	for _, a := range v {
+		for _, b := range other {
+			min, max := a.Min, a.Max
+			if b.Min > min {
+				min = b.Min
+			}
+			if b.Max < max {
+				max = b.Max
+			}
+			if min <= max {
+				res = append(res, VersionRange{Min: min, Max: max})
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Min < res[j].Min })
+	return res
+}
+
+// Highest returns the highest version in the set,
+// or ErrNoCommonVersion if the set is empty.
+func (v Versions) Highest() (int, error) {
+	highest := 0
+	for _, r := range v {
+		if r.Max > highest {
+			highest = r.Max
+		}
+	}
+	if highest == 0 {
+		return 0, ErrNoCommonVersion
+	}
+	return highest, nil
+}
+
+// Negotiate returns the highest version that is in both this set
+// and the given set. ErrNoCommonVersion is returned if there is none.
+func (v Versions) Negotiate(other Versions) (int, error) {
+	return v.Intersect(other).Highest()
+}
+
+// Strings returns the set in the form used by the Ver
+// field of client and server messages eg. ["1", "3-5"]
+func (v Versions) Strings() []string {
+	res := make([]string, len(v))
+	for i, r := range v {
+		res[i] = strconv.Itoa(r.Min)
+		if r.Max != r.Min {
+			res[i] += "-" + strconv.Itoa(r.Max)
+		}
+	}
+	return res
+}
+
+func (v Versions) String() string {
+	return strings.Join(v.Strings(), ",")
+}
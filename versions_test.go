@@ -0,0 +1,98 @@
+package sqrl_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/RaniSputnik/sqrl-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersions(t *testing.T) {
+	t.Run("ParsesListsAndRanges", func(t *testing.T) {
+		cases := map[string]sqrl.Versions{
+			"1":       {{Min: 1, Max: 1}},
+			"1,2":     {{Min: 1, Max: 1}, {Min: 2, Max: 2}},
+			"1-3":     {{Min: 1, Max: 3}},
+			"1,3-5,7": {{Min: 1, Max: 1}, {Min: 3, Max: 5}, {Min: 7, Max: 7}},
+		}
+		for input, expected := range cases {
+			got, err := sqrl.ParseVersions(input)
+			if assert.NoError(t, err, input) {
+				assert.Equal(t, expected, got, input)
+				assert.Equal(t, input, got.String())
+			}
+		}
+	})
+
+	t.Run("ReturnsParseErrorForInvalidVersions", func(t *testing.T) {
+		cases := map[string]sqrl.ParseReason{
+			"":     sqrl.ReasonMissing,
+			"one":  sqrl.ReasonMalformed,
+			"0":    sqrl.ReasonMalformed,
+			"-1":   sqrl.ReasonMalformed,
+			"1,":   sqrl.ReasonMalformed,
+			"3-1":  sqrl.ReasonMalformed,
+			"1-":   sqrl.ReasonMalformed,
+			"1-2-": sqrl.ReasonMalformed,
+		}
+		for input, reason := range cases {
+			_, err := sqrl.ParseVersions(input)
+			var perr *sqrl.ParseError
+			if assert.True(t, errors.As(err, &perr), "Expected a *ParseError for '%s', got: %v", input, err) {
+				assert.Equal(t, "ver", perr.Field)
+				assert.Equal(t, reason, perr.Reason, input)
+			}
+		}
+	})
+}
+
+func TestVersionsContains(t *testing.T) {
+	versions := sqrl.Versions{{Min: 1, Max: 1}, {Min: 3, Max: 5}}
+	assert.True(t, versions.Contains(1))
+	assert.False(t, versions.Contains(2))
+	assert.True(t, versions.Contains(3))
+	assert.True(t, versions.Contains(5))
+	assert.False(t, versions.Contains(6))
+}
+
+func TestVersionsIntersect(t *testing.T) {
+	a := sqrl.Versions{{Min: 1, Max: 4}, {Min: 7, Max: 9}}
+	b := sqrl.Versions{{Min: 8, Max: 10}, {Min: 3, Max: 5}}
+
+	assert.Equal(t, sqrl.Versions{{Min: 3, Max: 4}, {Min: 8, Max: 9}}, a.Intersect(b))
+	assert.Empty(t, a.Intersect(sqrl.Versions{{Min: 5, Max: 6}}))
+}
+
+func TestVersionsNegotiate(t *testing.T) {
+	t.Run("ReturnsHighestCommonVersion", func(t *testing.T) {
+		client := sqrl.Versions{{Min: 1, Max: 3}}
+		server := sqrl.Versions{{Min: 1, Max: 1}, {Min: 2, Max: 2}, {Min: 4, Max: 4}}
+
+		got, err := client.Negotiate(server)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, got)
+	})
+
+	t.Run("ReturnsErrNoCommonVersion", func(t *testing.T) {
+		client := sqrl.Versions{{Min: 2, Max: 3}}
+		server := sqrl.Versions{{Min: 1, Max: 1}}
+
+		_, err := client.Negotiate(server)
+		assert.Equal(t, sqrl.ErrNoCommonVersion, err)
+	})
+}
+
+func TestMsgVersions(t *testing.T) {
+	client := &sqrl.ClientMsg{Ver: []string{"1", "3-5"}}
+	got, err := client.Versions()
+	if assert.NoError(t, err) {
+		assert.Equal(t, sqrl.Versions{{Min: 1, Max: 1}, {Min: 3, Max: 5}}, got)
+	}
+
+	server := &sqrl.ServerMsg{Ver: []string{"1-2"}}
+	got, err = server.Versions()
+	if assert.NoError(t, err) {
+		assert.Equal(t, sqrl.Versions{{Min: 1, Max: 2}}, got)
+	}
+}